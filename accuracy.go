@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/insomniacslk/openweathermap"
+)
+
+// cmdAccuracy prints how accurate the recorded forecasts were.
+const cmdAccuracy = "accuracy"
+
+// accuracyMaxDistance is how far from the forecast time an observation can be
+// to verify it.
+const accuracyMaxDistance = 30 * time.Minute
+
+// accuracyPrecipThreshold is the probability of precipitation from which a
+// forecast counts as precipitating.
+const accuracyPrecipThreshold = 0.5
+
+// accuracyLeads are the lead time ranges the statistics are computed for.
+// Each one includes its maximum, and excludes the previous one's.
+var accuracyLeads = []struct {
+	name string
+	max  time.Duration
+}{
+	{"0-6h", 6 * time.Hour},
+	{"6-12h", 12 * time.Hour},
+	{"12-24h", 24 * time.Hour},
+	{"24-48h", 48 * time.Hour},
+}
+
+// leadIndex returns the index of the range of accuracyLeads a lead time falls
+// in, or -1.
+func leadIndex(lead time.Duration) int {
+	if lead <= 0 {
+		return -1
+	}
+	for i, l := range accuracyLeads {
+		if lead <= l.max {
+			return i
+		}
+	}
+	return -1
+}
+
+// precipitating returns true if it is raining or snowing, including drizzle
+// and thunderstorms.
+func precipitating(p *openweathermap.PointWeatherSummary) bool {
+	if p.Rain.OneHour != nil && *p.Rain.OneHour > 0 || p.Snow.OneHour != nil && *p.Snow.OneHour > 0 {
+		return true
+	}
+	for _, w := range p.Weather {
+		// see https://openweathermap.org/weather-conditions
+		switch w.ID / 100 {
+		case 2, 3, 5, 6:
+			return true
+		}
+	}
+	return false
+}
+
+// accuracySample is the temperature and precipitation at a time, either
+// observed or forecast.
+type accuracySample struct {
+	t time.Time
+	// tempC is in degrees Celsius, so that samples recorded with different
+	// units can be compared.
+	tempC  float64
+	precip bool
+}
+
+// accuracyStats are the errors of the forecasts of a provider at a location,
+// for a range of lead times.
+type accuracyStats struct {
+	Provider string `json:"provider"`
+	Location string `json:"location"`
+	Lead     string `json:"lead"`
+	// Samples is the number of forecast hours that could be verified.
+	Samples int `json:"samples"`
+	// TempMAE is the mean absolute error of the temperature, in degrees
+	// Celsius.
+	TempMAE float64 `json:"temp_mae"`
+	// PrecipHitRate is the share of hours in which the forecast was right
+	// about whether it would rain or snow, between 0 and 1.
+	PrecipHitRate float64 `json:"precip_hit_rate"`
+
+	lead int
+}
+
+// tempError returns the mean absolute error of the temperature in the given
+// units.
+func (s *accuracyStats) tempError(units string) float64 {
+	return fromCelsius(s.TempMAE, units) - fromCelsius(0, units)
+}
+
+// summary returns the statistics on one line, like "Dublin, Ireland
+// (openweathermap), 0-6h: ±0.8°C, precipitation 88% right over 120 hours".
+func (s *accuracyStats) summary(units string) string {
+	return fmt.Sprintf("%s (%s), %s: ±%.01f%s, precipitation %.0f%% right over %d hours",
+		s.Location, s.Provider, s.Lead,
+		s.tempError(units), openweathermap.TempUnits[openweathermap.Units(units)],
+		s.PrecipHitRate*100, s.Samples,
+	)
+}
+
+// computeAccuracy verifies the forecasts recorded in the history since the
+// given time against the observations that followed them, at the locations
+// matching the given name, or all of them if empty.
+func computeAccuracy(file, location string, since time.Time) ([]accuracyStats, error) {
+	type forecast struct {
+		accuracySample
+		provider, location string
+		lead               int
+	}
+	var (
+		forecasts    []forecast
+		observations = make(map[string][]accuracySample)
+	)
+	err := readHistory(file, func(o *observation) error {
+		if !matchLocation(o.Location, location) {
+			return nil
+		}
+		if c := o.Current; c != nil {
+			observations[o.Location] = append(observations[o.Location], accuracySample{
+				t:      time.Unix(c.Dt, 0),
+				tempC:  toCelsius(c.Temp, o.Units),
+				precip: precipitating(c),
+			})
+		}
+		if o.Time.Before(since) {
+			return nil
+		}
+		provider := o.Provider
+		if provider == "" {
+			provider = providerOpenWeatherMap
+		}
+		for _, h := range o.Hourly {
+			t := time.Unix(h.Dt, 0)
+			lead := leadIndex(t.Sub(o.Time))
+			if lead == -1 {
+				continue
+			}
+			forecasts = append(forecasts, forecast{
+				accuracySample: accuracySample{
+					t:      t,
+					tempC:  toCelsius(h.Temp, o.Units),
+					precip: h.Pop >= accuracyPrecipThreshold,
+				},
+				provider: provider,
+				location: o.Location,
+				lead:     lead,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		provider, location string
+		lead               int
+	}
+	stats := make(map[key]*accuracyStats)
+	for _, obs := range observations {
+		sort.Slice(obs, func(i, j int) bool { return obs[i].t.Before(obs[j].t) })
+	}
+	for _, f := range forecasts {
+		// find the closest observation
+		obs := observations[f.location]
+		i := sort.Search(len(obs), func(i int) bool { return !obs[i].t.Before(f.t) })
+		closest := -1
+		for _, j := range []int{i - 1, i} {
+			if j < 0 || j >= len(obs) {
+				continue
+			}
+			if closest == -1 || absDuration(obs[j].t.Sub(f.t)) < absDuration(obs[closest].t.Sub(f.t)) {
+				closest = j
+			}
+		}
+		if closest == -1 || absDuration(obs[closest].t.Sub(f.t)) > accuracyMaxDistance {
+			continue
+		}
+		k := key{f.provider, f.location, f.lead}
+		s, ok := stats[k]
+		if !ok {
+			s = &accuracyStats{
+				Provider: f.provider,
+				Location: f.location,
+				Lead:     accuracyLeads[f.lead].name,
+				lead:     f.lead,
+			}
+			stats[k] = s
+		}
+		s.Samples++
+		s.TempMAE += math.Abs(f.tempC - obs[closest].tempC)
+		if f.precip == obs[closest].precip {
+			s.PrecipHitRate++
+		}
+	}
+
+	ret := make([]accuracyStats, 0, len(stats))
+	for _, s := range stats {
+		s.TempMAE /= float64(s.Samples)
+		s.PrecipHitRate /= float64(s.Samples)
+		ret = append(ret, *s)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Location != ret[j].Location {
+			return ret[i].Location < ret[j].Location
+		}
+		if ret[i].Provider != ret[j].Provider {
+			return ret[i].Provider < ret[j].Provider
+		}
+		return ret[i].lead < ret[j].lead
+	})
+	return ret, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// accuracyOptions are the options of the accuracy command.
+type accuracyOptions struct {
+	location string
+	days     int
+	asJSON   bool
+}
+
+// parseAccuracy parses the arguments of the accuracy command.
+func parseAccuracy(args []string) (*accuracyOptions, error) {
+	var opts accuracyOptions
+	fs := flag.NewFlagSet(cmdAccuracy, flag.ContinueOnError)
+	fs.StringVar(&opts.location, "location", "", "Only show this location, e.g. Dublin. Defaults to all locations")
+	fs.IntVar(&opts.days, "days", 0, "Only verify the forecasts recorded in the last days. Defaults to the whole history")
+	fs.BoolVar(&opts.asJSON, "json", false, "Print the result as JSON instead of a table")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n\nCompare the forecasts recorded in the history with the observations that followed them.\n\nFlags:\n", progname, cmdAccuracy)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return nil, fmt.Errorf("unexpected arguments, use -location to select a location")
+	}
+	if opts.days < 0 {
+		return nil, fmt.Errorf("-days cannot be negative")
+	}
+	return &opts, nil
+}
+
+// runAccuracy prints the accuracy of the forecasts in the history to w, with
+// temperatures in the configured units.
+func runAccuracy(file string, cfg *Config, opts *accuracyOptions, w io.Writer) error {
+	var since time.Time
+	if opts.days > 0 {
+		since = time.Now().AddDate(0, 0, -opts.days)
+	}
+	stats, err := computeAccuracy(file, opts.location, since)
+	if err != nil {
+		return err
+	}
+	if opts.asJSON {
+		for i := range stats {
+			stats[i].TempMAE = stats[i].tempError(cfg.Units)
+		}
+		return printJSON(w, struct {
+			Units string          `json:"units"`
+			Stats []accuracyStats `json:"stats"`
+		}{cfg.Units, stats})
+	}
+	if len(stats) == 0 {
+		fmt.Fprintf(w, "No forecast could be verified yet, history must be enabled in the configuration file for a few hours\n")
+		return nil
+	}
+	tempUnit := openweathermap.TempUnits[openweathermap.Units(cfg.Units)]
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "LOCATION\tPROVIDER\tLEAD TIME\tHOURS\tTEMP MAE\tPRECIP HIT RATE\n")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%.01f%s\t%.0f%%\n",
+			s.Location, s.Provider, s.Lead, s.Samples,
+			s.tempError(cfg.Units), tempUnit,
+			s.PrecipHitRate*100,
+		)
+	}
+	return tw.Flush()
+}
+
+// accuracy parses the arguments, loads the configuration and runs the accuracy
+// command, exiting on error.
+func accuracy(args []string) {
+	opts, err := parseAccuracy(args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", progname, cmdAccuracy, err)
+		os.Exit(2)
+	}
+	_, cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config file: %v\n", err)
+		os.Exit(1)
+	}
+	file, err := historyFile()
+	if err == nil {
+		err = runAccuracy(file, cfg, opts, os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", progname, cmdAccuracy, err)
+		os.Exit(1)
+	}
+}
+
+// watchAccuracy calls fn with the accuracy of the forecasts in the history now
+// and then every hour, until ctx is done.
+func watchAccuracy(ctx context.Context, fn func([]accuracyStats)) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		file, err := historyFile()
+		if err == nil {
+			var stats []accuracyStats
+			if stats, err = computeAccuracy(file, "", time.Time{}); err == nil {
+				fn(stats)
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to compute forecast accuracy: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
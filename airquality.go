@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// airPollutionURL is OpenWeatherMap's current air pollution API.
+const airPollutionURL = "https://api.openweathermap.org/data/2.5/air_pollution"
+
+// airQuality is the current air quality at a location. Concentrations are in
+// μg/m³.
+type airQuality struct {
+	// AQI is OpenWeatherMap's air quality index, from 1 (good) to 5 (very
+	// poor).
+	AQI  int     `json:"aqi"`
+	PM25 float64 `json:"pm2_5"`
+	PM10 float64 `json:"pm10"`
+	O3   float64 `json:"o3"`
+	NO2  float64 `json:"no2"`
+}
+
+// aqiLevels are the names of the AQI levels, from 1 to 5, and the emoji used
+// as their colour code.
+var aqiLevels = []struct {
+	name, emoji string
+}{
+	{"Good", "🟢"},
+	{"Fair", "🟡"},
+	{"Moderate", "🟠"},
+	{"Poor", "🔴"},
+	{"Very poor", "🟣"},
+}
+
+// formatAQI returns the colour-coded level of an AQI, e.g. "🟡 Fair".
+func formatAQI(aqi int) string {
+	if aqi < 1 || aqi > len(aqiLevels) {
+		return "unknown"
+	}
+	l := aqiLevels[aqi-1]
+	return l.emoji + " " + l.name
+}
+
+// airPollutionResponse is the response of the air pollution API.
+type airPollutionResponse struct {
+	List []struct {
+		Dt   int64 `json:"dt"`
+		Main struct {
+			AQI int `json:"aqi"`
+		} `json:"main"`
+		Components struct {
+			PM25 float64 `json:"pm2_5"`
+			PM10 float64 `json:"pm10"`
+			O3   float64 `json:"o3"`
+			NO2  float64 `json:"no2"`
+		} `json:"components"`
+	} `json:"list"`
+}
+
+// getAirQuality returns the current air quality at the given location. It
+// counts as an OpenWeatherMap API call.
+func getAirQuality(ctx context.Context, cfg *Config, loc *location) (*airQuality, error) {
+	if err := apiUsage.Reserve(cfg, providerOpenWeatherMap); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout))
+	defer cancel()
+	q := url.Values{}
+	q.Set("lat", fmt.Sprintf("%f", loc.lat))
+	q.Set("lon", fmt.Sprintf("%f", loc.lon))
+	q.Set("appid", cfg.OpenweathermapAPIKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, airPollutionURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	aq, err := doAirQualityRequest(req)
+	countCall(providerOpenWeatherMap, err)
+	return aq, err
+}
+
+func doAirQualityRequest(req *http.Request) (*airQuality, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("air pollution API returned %s", resp.Status)
+	}
+	var r airPollutionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("failed to decode air pollution response: %w", err)
+	}
+	if len(r.List) == 0 {
+		return nil, fmt.Errorf("no air pollution data")
+	}
+	d := r.List[0]
+	return &airQuality{
+		AQI:  d.Main.AQI,
+		PM25: d.Components.PM25,
+		PM10: d.Components.PM10,
+		O3:   d.Components.O3,
+		NO2:  d.Components.NO2,
+	}, nil
+}
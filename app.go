@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// app is the part of wea that does not depend on the tray: it fetches the
+// weather for all the locations on schedule, stores it in the cache, and
+// serves it over the command socket, D-Bus and HTTP. The tray, when present,
+// is just another consumer of the cache.
+type app struct {
+	configFile string
+	cfg        *Config
+	inst       *instance
+	cache      *weatherCache
+	// graph is the temperature graph of the current location, or nil if
+	// show_graph is disabled.
+	graph *Graph
+	locs  []location
+	sched *scheduler
+	u     updater
+	// notifier sends the desktop notifications.
+	notifier *notifier
+	// dbusConn and httpServer are the external interfaces, or nil if they
+	// are not running.
+	dbusConn   *dbus.Conn
+	httpServer *http.Server
+
+	// quit terminates the program. It is set by the user interface before
+	// calling Start.
+	quit func()
+	// onSchedule, if not nil, is called every time the next automatic
+	// update is scheduled. It is set by the user interface before calling
+	// Start.
+	onSchedule func(next time.Time, interval time.Duration)
+
+	// aqiAbove is true if a notification was sent because the air quality
+	// index of the current location reached aqi_threshold, and it has not
+	// gone back below since.
+	aqiAbove bool
+}
+
+// refreshSignals receives SIGUSR1, which requests an update once the app has
+// started.
+var refreshSignals = make(chan os.Signal, 1)
+
+// catchRefreshSignal stops SIGUSR1 from killing the process, which is its
+// default action, so that the processes that are starting up or never update
+// the weather, like status bar clients and one-shot commands, survive it.
+func catchRefreshSignal() {
+	signal.Notify(refreshSignals, syscall.SIGUSR1)
+}
+
+// newApp geocodes the configured locations and returns a new app.
+func newApp(ctx context.Context, configFile string, cfg *Config, inst *instance) (*app, error) {
+	a := app{
+		configFile: configFile,
+		cfg:        cfg,
+		inst:       inst,
+		cache:      newWeatherCache(),
+		notifier:   newNotifier(cfg),
+	}
+	if cfg.ShowGraph {
+		a.graph = NewGraph(100, 100, &darkGreen, &gray, graphStyleBar)
+		a.graph.Blank()
+	}
+	for _, locName := range cfg.Locations {
+		loc, err := getLocation(ctx, cfg, locName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get location '%s': %w", locName, err)
+		}
+		a.locs = append(a.locs, *loc)
+	}
+	a.cache.SetLocations(cfg.Locations, a.locs)
+	return &a, nil
+}
+
+// interval returns the interval between automatic updates, slowed down if
+// needed to stay within the API budgets. Each update fetches the weather, and
+// the air quality if enabled, for every location plus the current one, and
+// geocodes the current location at most once. It is zero if updates are
+// only done on request, whatever the budgets.
+func (a *app) interval() time.Duration {
+	if a.cfg.Interval == 0 {
+		return 0
+	}
+	owmCalls := len(a.locs) + 1
+	if a.cfg.AirQuality {
+		owmCalls *= 2
+	}
+	interval := apiUsage.Interval(a.cfg, map[string]int{
+		providerOpenWeatherMap: owmCalls,
+		providerGoogleMaps:     1,
+	})
+	if interval != time.Duration(a.cfg.Interval) {
+		log.Printf("Slowing down updates to every %s to stay within the API budget", interval.Round(time.Second))
+	}
+	return interval
+}
+
+// Start starts the scheduler and the external interfaces, and requests the
+// first update. Everything stops when ctx is done.
+func (a *app) Start(ctx context.Context) {
+	a.sched = newScheduler(a.cfg, a.interval, a.onSchedule)
+	go a.sched.Run(ctx)
+	if err := watchResume(ctx, a.sched.Resumed); err != nil {
+		log.Printf("Cannot watch for system resume, relying on clock jumps instead: %v", err)
+	}
+	// the notifications of each update are sent together, after all the
+	// checks
+	if a.cfg.AQIThreshold > 0 {
+		a.cache.OnUpdate(a.checkAQI)
+	}
+	if len(a.cfg.Rules) > 0 {
+		a.cache.OnUpdate(newRulesEngine(a.cfg, a.cache, a.notifier).Evaluate)
+	}
+	a.cache.OnUpdate(a.notifier.Flush)
+	if a.cfg.History {
+		h, err := openHistory(a.cfg)
+		if err != nil {
+			log.Printf("History disabled: %v", err)
+		} else {
+			h.Watch(a.cache)
+		}
+	}
+	if a.cfg.Briefing != nil {
+		b, err := newBriefing(a.cfg, a.cache, a.notifier)
+		if err != nil {
+			log.Printf("Morning briefing disabled: %v", err)
+		} else {
+			a.cache.OnUpdate(b.OnUpdate)
+			go b.Run(ctx, a.Refresh)
+		}
+	}
+	if conn, err := startDBusService(a.cache, a.Refresh, a.reload); err != nil {
+		log.Printf("D-Bus service not available: %v", err)
+	} else {
+		a.dbusConn = conn
+	}
+	if a.cfg.HTTPListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/v1/", newAPIHandler(a.cache, a.graph))
+		mux.Handle("/metrics", newMetricsHandler(a.cfg, a.cache))
+		if srv, err := startHTTPServer(ctx, a.cfg, mux); err != nil {
+			log.Printf("Failed to start HTTP API: %v", err)
+		} else {
+			a.httpServer = srv
+		}
+	}
+	// the first update is about to start, so earlier requests are moot
+	select {
+	case <-refreshSignals:
+	default:
+	}
+	go func() {
+		for range refreshSignals {
+			log.Printf("Received SIGUSR1, updating weather")
+			a.Refresh()
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			log.Printf("Received %s, quitting", sig)
+			a.quit()
+		}
+	}()
+	go a.inst.Serve(func(cmd string) (func(), error) {
+		switch cmd {
+		case cmdRefresh:
+			a.Refresh()
+			return nil, nil
+		case cmdReload:
+			return a.reload()
+		default:
+			return nil, fmt.Errorf("unknown command '%s'", cmd)
+		}
+	}, func(conn net.Conn) {
+		streamCache(conn, a.cache)
+	})
+
+	if a.cfg.Interval == 0 {
+		log.Printf("Weather will only be updated on request")
+	} else {
+		log.Printf("Updating weather every %s", a.cfg.Interval)
+	}
+	// Updates run on their own goroutine, so that the user interface stays
+	// responsive while requests are in flight.
+	a.u.Run(ctx, a.update)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				a.u.Stop()
+				return
+			case <-a.sched.C:
+				a.u.Run(ctx, a.update)
+			}
+		}
+	}()
+}
+
+// checkAQI queues a notification when the air quality index of the current
+// location reaches aqi_threshold. It notifies again only after the index has
+// gone back below the threshold.
+func (a *app) checkAQI() {
+	cur := a.cache.Current()
+	if cur == nil || cur.AirQuality == nil {
+		return
+	}
+	aqi := cur.AirQuality.AQI
+	switch {
+	case aqi < a.cfg.AQIThreshold:
+		a.aqiAbove = false
+	case !a.aqiAbove:
+		log.Printf("Air quality in %s is %s", cur.Name, formatAQI(aqi))
+		a.notifier.Queue(
+			fmt.Sprintf("Air quality in %s: %s", cur.Name, formatAQI(aqi)),
+			fmt.Sprintf("PM2.5 %.0f μg/m³, PM10 %.0f μg/m³", cur.AirQuality.PM25, cur.AirQuality.PM10),
+			func() { a.aqiAbove = true },
+		)
+	}
+}
+
+// Refresh starts an update now. A running update, if any, is cancelled.
+func (a *app) Refresh() {
+	a.sched.fire()
+}
+
+// update fetches the weather and reports the outcome to the scheduler, unless
+// it was cancelled.
+func (a *app) update(ctx context.Context) {
+	err := updateWeather(ctx, a.cfg, a.cache, a.locs, a.graph)
+	if ctx.Err() == nil {
+		a.sched.Done(err)
+	}
+}
+
+// closeServices releases the instance lock and stops the command socket, the
+// D-Bus service and the HTTP server, so that a new process can take them over.
+func (a *app) closeServices() {
+	if err := a.inst.Close(); err != nil {
+		log.Printf("Failed to release instance lock: %v", err)
+	}
+	if a.dbusConn != nil {
+		if err := a.dbusConn.Close(); err != nil {
+			log.Printf("Failed to close D-Bus connection: %v", err)
+		}
+	}
+	if a.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := a.httpServer.Shutdown(ctx); err != nil {
+			log.Printf("Failed to shut down HTTP server: %v", err)
+		}
+	}
+}
+
+// reload checks that the configuration file is valid, and returns a function
+// that restarts the program, which will start from scratch with the new
+// configuration.
+func (a *app) reload() (func(), error) {
+	if _, _, err := loadConfig(); err != nil {
+		return nil, fmt.Errorf("not reloading, invalid config: %w", err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot find executable: %w", err)
+	}
+	return func() {
+		log.Printf("Restarting to reload the configuration")
+		a.closeServices()
+		if err := restart(exe); err != nil {
+			log.Printf("Failed to restart, quitting: %v", err)
+		}
+		a.quit()
+	}, nil
+}
+
+func updateCurrentLocation(ctx context.Context, cfg *Config, cache *weatherCache, g *Graph) error {
+	curLocName, err := getCurrentLocation(ctx, cfg)
+	if err != nil {
+		log.Printf("Cannot get current location: %v", err)
+		return err
+	}
+	curLoc, err := getLocation(ctx, cfg, curLocName)
+	if ctx.Err() != nil {
+		// superseded or shutting down, leave the cache alone
+		return ctx.Err()
+	}
+	if err != nil {
+		log.Printf("Failed to get location '%s': %v", curLocName, err)
+		cache.SetCurrentFailed(err)
+		return err
+	}
+	curLocWea, err := getWeather(ctx, cfg, curLoc)
+	aq := updateAirQuality(ctx, cfg, curLoc)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	cache.SetCurrent(curLoc, curLocWea, aq, err)
+	if err != nil {
+		log.Printf("failed to get weather for '%s': %v", curLoc.name, err)
+		return err
+	}
+	if g != nil {
+		g.SetNext(int(curLocWea.Current.Temp))
+	}
+	return nil
+}
+
+// updateLocation updates the configured location with the given index.
+func updateLocation(ctx context.Context, cfg *Config, cache *weatherCache, index int, loc location) error {
+	wea, err := getWeather(ctx, cfg, &loc)
+	aq := updateAirQuality(ctx, cfg, &loc)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	cache.Set(index, wea, aq, err)
+	if err != nil {
+		log.Printf("failed to get weather for '%s': %v", loc.name, err)
+	}
+	return err
+}
+
+// updateAirQuality returns the air quality at loc if air_quality is enabled,
+// or nil if it is disabled or failed. Failures are only logged, since the
+// weather is still useful without it.
+func updateAirQuality(ctx context.Context, cfg *Config, loc *location) *airQuality {
+	if !cfg.AirQuality {
+		return nil
+	}
+	aq, err := getAirQuality(ctx, cfg, loc)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("failed to get air quality for '%s': %v", loc.name, err)
+		}
+		return nil
+	}
+	return aq
+}
+
+// updateWeather fetches the weather for the current location and for all the
+// configured locations concurrently, using at most cfg.Concurrency workers,
+// and returns once all of them are done. The results are stored in the cache,
+// whose listeners are then notified. If ctx is cancelled, the pending requests
+// are abandoned and the listeners are not notified. It returns an error if any
+// of the locations failed to update.
+func updateWeather(ctx context.Context, cfg *Config, cache *weatherCache, locs []location, g *Graph) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures int
+	)
+	countFailure := func(err error) {
+		if err != nil {
+			mu.Lock()
+			failures++
+			mu.Unlock()
+		}
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		countFailure(updateCurrentLocation(ctx, cfg, cache, g))
+	}()
+	// jobs are indexes in locs
+	jobs := make(chan int)
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				countFailure(updateLocation(ctx, cfg, cache, i, locs[i]))
+			}
+		}()
+	}
+loop:
+	for i := range locs {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	cache.Updated()
+	if failures > 0 {
+		return fmt.Errorf("%d location(s) failed to update", failures)
+	}
+	return nil
+}
+
+// runHeadless runs the app without any user interface, until ctx is done.
+func runHeadless(ctx context.Context, cancel context.CancelFunc, a *app) {
+	a.quit = cancel
+	a.cache.OnUpdate(func() {
+		log.Printf("Weather updated, %s", apiUsage.Summary(a.cfg))
+	})
+	a.Start(ctx)
+	<-ctx.Done()
+}
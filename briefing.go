@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/openweathermap"
+	"github.com/kirsle/configdir"
+)
+
+// briefingRainThreshold is the probability of precipitation above which an
+// hour is part of a rain window in the briefing.
+const briefingRainThreshold = 0.5
+
+// briefingDefaultUntil is the default time after which a briefing that could
+// not be sent is skipped, in minutes since midnight: noon, or the end of the
+// day for briefings at noon or later.
+const briefingDefaultUntil = 12 * 60
+
+// BriefingConfig configures the morning briefing, a daily notification
+// summarising the day ahead.
+type BriefingConfig struct {
+	// Time is the local time of the briefing, like "07:30".
+	Time string `json:"time"`
+	// Until is the local time after which the briefing is skipped for the
+	// day if it could not be sent yet, like "12:00".
+	Until string `json:"until"`
+	// Locations are configured locations to include in the briefing, in
+	// addition to the current location.
+	Locations []string `json:"locations"`
+
+	// at and until are the parsed Time and Until, in minutes since
+	// midnight.
+	at, until int
+	// indexes are the indexes of Locations in the configured locations.
+	indexes []int
+}
+
+// check validates the briefing configuration.
+func (b *BriefingConfig) check(cfg *Config) error {
+	var err error
+	if b.at, err = parseClock(b.Time); err != nil {
+		return fmt.Errorf("time: %w", err)
+	}
+	switch {
+	case b.Until != "":
+		if b.until, err = parseClock(b.Until); err != nil {
+			return fmt.Errorf("until: %w", err)
+		}
+		if b.until <= b.at {
+			return fmt.Errorf("until must be after time")
+		}
+	case b.at < briefingDefaultUntil:
+		b.until = briefingDefaultUntil
+	default:
+		b.until = 24 * 60
+	}
+	b.indexes = nil
+	for _, name := range b.Locations {
+		index := -1
+		for i, l := range cfg.Locations {
+			if strings.EqualFold(l, name) {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return fmt.Errorf("location '%s' is not one of the configured locations", name)
+		}
+		b.indexes = append(b.indexes, index)
+	}
+	return nil
+}
+
+// briefing sends the morning briefing once a day, after the first update that
+// follows the configured time. If the computer was asleep or wea was not
+// running at that time, it is sent after the next update, unless it is too
+// late for it, and the day it was last sent is saved to disk so that
+// restarting does not send it twice.
+type briefing struct {
+	cfg      *Config
+	cache    *weatherCache
+	notifier *notifier
+
+	mu       sync.Mutex
+	file     string
+	LastSent string `json:"last_sent"`
+}
+
+// newBriefing loads the day the briefing was last sent from the config
+// directory. A missing file is not an error.
+func newBriefing(cfg *Config, cache *weatherCache, n *notifier) (*briefing, error) {
+	configPath := configdir.LocalConfig(progname)
+	if err := configdir.MakePath(configPath); err != nil {
+		return nil, err
+	}
+	b := briefing{
+		cfg:      cfg,
+		cache:    cache,
+		notifier: n,
+		file:     path.Join(configPath, "briefing.json"),
+	}
+	data, err := os.ReadFile(b.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &b, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal briefing file: %w", err)
+	}
+	return &b, nil
+}
+
+// due returns true if now is between the briefing time and its cutoff, and the
+// briefing has not been sent yet today. Must be called with the lock held.
+func (b *briefing) due(now time.Time) bool {
+	m := minuteOfDay(now)
+	return m >= b.cfg.Briefing.at && m < b.cfg.Briefing.until && b.LastSent != now.Format(usageDayFormat)
+}
+
+// Run requests an update when the briefing is due, checking every minute,
+// until ctx is done. The briefing itself is sent by OnUpdate, with the fresh
+// weather.
+func (b *briefing) Run(ctx context.Context, refresh func()) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	requested := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			now = now.Round(0)
+			b.mu.Lock()
+			due := b.due(now)
+			b.mu.Unlock()
+			// request at most one update per day, the following ones
+			// happen on schedule
+			if today := now.Format(usageDayFormat); due && requested != today {
+				requested = today
+				log.Printf("Morning briefing is due, updating weather")
+				refresh()
+			}
+		}
+	}
+}
+
+// OnUpdate sends the briefing if it is due, unless notifications are quiet,
+// in which case it is sent after a later update.
+func (b *briefing) OnUpdate() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if !b.due(now) {
+		return
+	}
+	if reason := b.notifier.quiet(now); reason != "" {
+		log.Printf("Delaying morning briefing because of %s", reason)
+		return
+	}
+	var sections []string
+	if cur := b.cache.Current(); cur != nil && cur.Weather != nil {
+		sections = append(sections, b.summary(cur, now))
+	}
+	locs := b.cache.Locations()
+	for _, i := range b.cfg.Briefing.indexes {
+		if e := &locs[i]; e.Weather != nil {
+			sections = append(sections, b.summary(e, now))
+		}
+	}
+	if len(sections) == 0 {
+		log.Printf("Morning briefing is due, but no weather is available yet")
+		return
+	}
+	if err := notify("Good morning", strings.Join(sections, "\n\n")); err != nil {
+		// try again at the next update
+		log.Printf("Failed to send morning briefing: %v", err)
+		return
+	}
+	b.LastSent = now.Format(usageDayFormat)
+	data, err := json.Marshal(b)
+	if err != nil {
+		log.Printf("Failed to marshal briefing state: %v", err)
+		return
+	}
+	if err := os.WriteFile(b.file, data, 0o644); err != nil {
+		log.Printf("Failed to save briefing state: %v", err)
+	}
+}
+
+// rainWindows returns the time windows, like "10:00-13:00", during which rain
+// is likely in the given hours.
+func rainWindows(hourly []openweathermap.PointWeatherSummary, tz *time.Location) []string {
+	var (
+		windows    []string
+		start, end int64
+	)
+	flush := func() {
+		if start != 0 {
+			windows = append(windows, fmt.Sprintf("%s-%s", time.Unix(start, 0).In(tz).Format("15:04"), time.Unix(end, 0).In(tz).Format("15:04")))
+		}
+		start = 0
+	}
+	for _, h := range hourly {
+		if h.Pop < briefingRainThreshold {
+			flush()
+			continue
+		}
+		if start == 0 {
+			start = h.Dt
+		}
+		end = h.Dt + 3600
+	}
+	flush()
+	return windows
+}
+
+// summary returns the day ahead at a location: the high and low temperature,
+// the rain windows, the strongest wind, the active alerts, and sunrise and
+// sunset.
+func (b *briefing) summary(e *cacheEntry, now time.Time) string {
+	w := e.Weather
+	tz := timezone(w)
+	tempUnit := openweathermap.TempUnits[openweathermap.Units(b.cfg.Units)]
+	speedUnit := openweathermap.SpeedUnits[openweathermap.Units(b.cfg.Units)]
+	lines := []string{e.Name}
+	if len(w.Daily) > 0 {
+		d := w.Daily[0]
+		lines = append(lines, fmt.Sprintf("%.0f%s to %.0f%s, %s", d.Temp.Min, tempUnit, d.Temp.Max, tempUnit, description(d.CommonWeatherSummary)))
+	}
+
+	// the rest of the day at the location
+	local := now.In(tz)
+	endOfDay := time.Date(local.Year(), local.Month(), local.Day(), 23, 59, 59, 0, tz).Unix()
+	var today []openweathermap.PointWeatherSummary
+	for _, h := range w.Hourly {
+		if h.Dt+3600 > now.Unix() && h.Dt <= endOfDay {
+			today = append(today, h)
+		}
+	}
+	if windows := rainWindows(today, tz); len(windows) > 0 {
+		lines = append(lines, "Rain likely "+strings.Join(windows, ", "))
+	} else {
+		lines = append(lines, "No rain expected")
+	}
+	var wind float64
+	for _, h := range today {
+		if h.WindSpeed > wind {
+			wind = h.WindSpeed
+		}
+	}
+	if len(today) > 0 {
+		lines = append(lines, fmt.Sprintf("Wind up to %.01f %s", wind, speedUnit))
+	}
+	for _, a := range w.Alerts {
+		if a.End > now.Unix() {
+			lines = append(lines, "Alert: "+a.Event)
+		}
+	}
+	sun := getSunTimes(now, e.Lat, e.Lon)
+	if !sun.Sunrise.IsZero() {
+		lines = append(lines, fmt.Sprintf("Sunrise %s, sunset %s", sun.Sunrise.In(tz).Format("15:04"), sun.Sunset.In(tz).Format("15:04")))
+	}
+	return strings.Join(lines, "\n")
+}
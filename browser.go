@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// forecastURL is the forecast page opened from the menu of each location,
+// given its latitude and longitude. OpenWeatherMap has no forecast pages by
+// coordinates, but Yr, of the Norwegian Meteorological Institute, does.
+const forecastURL = "https://www.yr.no/en/forecast/daily-table/%.4f,%.4f"
+
+// openBrowser opens url in the default browser.
+func openBrowser(url string) error {
+	args := append(browserCommand[1:len(browserCommand):len(browserCommand)], url)
+	cmd := exec.Command(browserCommand[0], args...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run %s: %w", browserCommand[0], err)
+	}
+	go func() {
+		_ = cmd.Wait()
+	}()
+	return nil
+}
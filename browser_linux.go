@@ -0,0 +1,3 @@
+package main
+
+var browserCommand = []string{"xdg-open"}
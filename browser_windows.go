@@ -0,0 +1,3 @@
+package main
+
+var browserCommand = []string{"rundll32", "url.dll,FileProtocolHandler"}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/openweathermap"
+)
+
+// cacheEntry is the latest weather known for a location.
+type cacheEntry struct {
+	Name    string                  `json:"name"`
+	Lat     float64                 `json:"lat"`
+	Lon     float64                 `json:"lon"`
+	Updated time.Time               `json:"updated"`
+	Weather *openweathermap.Weather `json:"weather,omitempty"`
+	// AirQuality is the latest air quality, if air_quality is enabled.
+	AirQuality *airQuality `json:"air_quality,omitempty"`
+	// Error is the error of the latest update, if it failed. In that case
+	// Weather and Updated still refer to the previous successful update.
+	Error string `json:"error,omitempty"`
+
+	// configured is the name of a configured location as written in the
+	// configuration file, since several of them can geocode to the same
+	// Name.
+	configured string
+}
+
+// weatherCache holds the latest weather for the current location and for each
+// configured location. It is filled by updateWeather, and read by the tray and
+// by the other interfaces, so that they do not need to call the APIs.
+type weatherCache struct {
+	mu        sync.RWMutex
+	current   *cacheEntry
+	locations []*cacheEntry
+	// listeners are pointers so that they can be told apart on removal.
+	listeners []*func()
+}
+
+func newWeatherCache() *weatherCache {
+	return &weatherCache{}
+}
+
+// SetLocations sets the configured locations, in order, given their names in
+// the configuration file and their geocoded locations.
+func (c *weatherCache) SetLocations(names []string, locs []location) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.locations = make([]*cacheEntry, 0, len(locs))
+	for i, loc := range locs {
+		c.locations = append(c.locations, &cacheEntry{Name: loc.name, Lat: loc.lat, Lon: loc.lon, configured: names[i]})
+	}
+}
+
+func (c *weatherCache) set(e *cacheEntry, wea *openweathermap.Weather, aq *airQuality, err error) {
+	if aq != nil {
+		e.AirQuality = aq
+	}
+	if err != nil {
+		e.Error = err.Error()
+		return
+	}
+	e.Error = ""
+	e.Weather = wea
+	e.Updated = time.Now()
+}
+
+// Set stores the outcome of an update for the configured location with the
+// given index. A nil aq keeps the previous air quality.
+func (c *weatherCache) Set(index int, wea *openweathermap.Weather, aq *airQuality, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if index >= 0 && index < len(c.locations) {
+		c.set(c.locations[index], wea, aq, err)
+	}
+}
+
+// SetCurrent stores the outcome of an update for the current location. A nil
+// aq keeps the previous air quality.
+func (c *weatherCache) SetCurrent(loc *location, wea *openweathermap.Weather, aq *airQuality, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil || c.current.Name != loc.name {
+		c.current = &cacheEntry{Name: loc.name, Lat: loc.lat, Lon: loc.lon}
+	}
+	c.set(c.current, wea, aq, err)
+}
+
+// SetCurrentFailed records that the current location could not be found. The
+// previous current location, if any, is kept.
+func (c *weatherCache) SetCurrentFailed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil {
+		c.current = &cacheEntry{}
+	}
+	c.current.Error = err.Error()
+}
+
+// Current returns a copy of the entry for the current location, or nil if it
+// is not known yet.
+func (c *weatherCache) Current() *cacheEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.current == nil {
+		return nil
+	}
+	e := *c.current
+	return &e
+}
+
+// Get returns a copy of the entry for the location with the given name, which
+// is case-insensitive: either a configured location as written in the
+// configuration file, or a geocoded name. An empty name returns the current
+// location.
+func (c *weatherCache) Get(name string) (*cacheEntry, bool) {
+	if name == "" {
+		e := c.Current()
+		return e, e != nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, e := range c.locations {
+		if strings.EqualFold(e.configured, name) {
+			ret := *e
+			return &ret, true
+		}
+	}
+	for _, e := range c.locations {
+		if strings.EqualFold(e.Name, name) {
+			ret := *e
+			return &ret, true
+		}
+	}
+	if c.current != nil && strings.EqualFold(c.current.Name, name) {
+		ret := *c.current
+		return &ret, true
+	}
+	return nil, false
+}
+
+// Locations returns copies of the entries for the configured locations, in
+// order.
+func (c *weatherCache) Locations() []cacheEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := make([]cacheEntry, 0, len(c.locations))
+	for _, e := range c.locations {
+		ret = append(ret, *e)
+	}
+	return ret
+}
+
+// OnUpdate registers a function to be called after every update. It returns
+// a function that unregisters it.
+func (c *weatherCache) OnUpdate(fn func()) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := &fn
+	c.listeners = append(c.listeners, l)
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, other := range c.listeners {
+			if other == l {
+				// copy, as Updated may be iterating over the old slice
+				c.listeners = append(c.listeners[:i:i], c.listeners[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Updated notifies the listeners that an update has completed.
+func (c *weatherCache) Updated() {
+	c.mu.RLock()
+	listeners := c.listeners
+	c.mu.RUnlock()
+	for _, fn := range listeners {
+		(*fn)()
+	}
+}
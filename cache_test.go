@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/insomniacslk/openweathermap"
+)
+
+func TestWeatherCacheSameGeocodedName(t *testing.T) {
+	c := newWeatherCache()
+	c.SetLocations([]string{"Dublin, Ireland", "Dublin, CA"}, []location{
+		{name: "Dublin", lat: 53.35, lon: -6.26},
+		{name: "Dublin", lat: 37.70, lon: -121.94},
+	})
+	ireland := &openweathermap.Weather{Lat: 53.35}
+	c.Set(1, nil, nil, errors.New("failed"))
+	c.Set(0, ireland, nil, nil)
+
+	locs := c.Locations()
+	if locs[0].Weather != ireland || locs[0].Error != "" {
+		t.Errorf("first entry = %+v, want the weather of Ireland", locs[0])
+	}
+	if locs[1].Weather != nil || locs[1].Error != "failed" {
+		t.Errorf("second entry = %+v, want the error", locs[1])
+	}
+	for _, tc := range []struct {
+		name string
+		lat  float64
+	}{
+		{"dublin, ireland", 53.35},
+		{"Dublin, CA", 37.70},
+		{"dublin", 53.35},
+	} {
+		e, ok := c.Get(tc.name)
+		if !ok || e.Lat != tc.lat {
+			t.Errorf("Get(%q) = %+v, %v, want latitude %g", tc.name, e, ok, tc.lat)
+		}
+	}
+	if _, ok := c.Get("Cork"); ok {
+		t.Errorf("Get(\"Cork\") found an entry")
+	}
+}
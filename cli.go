@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/insomniacslk/openweathermap"
+)
+
+// one-shot commands, which fetch the weather, print it and exit, without
+// needing a running instance.
+const (
+	cmdNow      = "now"
+	cmdForecast = "forecast"
+	cmdHourly   = "hourly"
+)
+
+// timezone returns the timezone of the location the weather refers to.
+func timezone(w *openweathermap.Weather) *time.Location {
+	if tz, err := time.LoadLocation(w.Timezone); err == nil {
+		return tz
+	}
+	return time.FixedZone(w.Timezone, w.TimezoneOffset)
+}
+
+// description returns the description of the weather conditions.
+func description(s openweathermap.CommonWeatherSummary) string {
+	descs := make([]string, 0, len(s.Weather))
+	for _, w := range s.Weather {
+		descs = append(descs, w.Description)
+	}
+	return strings.Join(descs, ", ")
+}
+
+// resolveLocation geocodes the given location name, or returns the current
+// location if the name is empty.
+func resolveLocation(ctx context.Context, cfg *Config, name string) (*location, error) {
+	if name == "" {
+		var err error
+		name, err = getCurrentLocation(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get current location: %w", err)
+		}
+	}
+	return getLocation(ctx, cfg, name)
+}
+
+// oneShotOptions are the options of a one-shot command.
+type oneShotOptions struct {
+	cmd      string
+	location string
+	asJSON   bool
+	days     int
+	hours    int
+}
+
+// parseOneShot parses the arguments of a one-shot command.
+func parseOneShot(cmd string, args []string) (*oneShotOptions, error) {
+	opts := oneShotOptions{cmd: cmd}
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.BoolVar(&opts.asJSON, "json", false, "Print the result as JSON instead of a table")
+	switch cmd {
+	case cmdNow:
+	case cmdForecast:
+		fs.IntVar(&opts.days, "days", 3, "Number of days to show, from 1 to 8")
+	case cmdHourly:
+		fs.IntVar(&opts.hours, "hours", 12, "Number of hours to show, from 1 to 48")
+	default:
+		return nil, fmt.Errorf("unknown command '%s'", cmd)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] [location]\n\nWithout a location, the current location is used. Flags can also follow the location.\n\nFlags:\n", progname, cmd)
+		fs.PrintDefaults()
+	}
+	// the flag package stops at the first argument that is not a flag, so
+	// parse again after each one, unless it follows "--"
+	var locs []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		if rest := fs.Args(); len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			locs = append(locs, rest...)
+			break
+		}
+		locs = append(locs, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(locs) > 1 {
+		fs.Usage()
+		return nil, fmt.Errorf("too many arguments, quote the location if it contains spaces")
+	}
+	if len(locs) == 1 {
+		opts.location = locs[0]
+	}
+	if cmd == cmdForecast && (opts.days < 1 || opts.days > 8) {
+		return nil, fmt.Errorf("-days must be from 1 to 8, got %d", opts.days)
+	}
+	if cmd == cmdHourly && (opts.hours < 1 || opts.hours > 48) {
+		return nil, fmt.Errorf("-hours must be from 1 to 48, got %d", opts.hours)
+	}
+	return &opts, nil
+}
+
+// runOneShot runs a one-shot command, printing the result to w.
+func runOneShot(ctx context.Context, cfg *Config, opts *oneShotOptions, w io.Writer) error {
+	loc, err := resolveLocation(ctx, cfg, opts.location)
+	if err != nil {
+		return err
+	}
+	wea, err := getWeather(ctx, cfg, loc)
+	if err != nil {
+		return fmt.Errorf("failed to get weather for '%s': %w", loc.name, err)
+	}
+	tz := timezone(wea)
+	tempUnit := openweathermap.TempUnits[openweathermap.Units(cfg.Units)]
+	speedUnit := openweathermap.SpeedUnits[openweathermap.Units(cfg.Units)]
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	switch opts.cmd {
+	case cmdNow:
+		if opts.asJSON {
+			return printJSON(w, struct {
+				Name    string                              `json:"name"`
+				Current *openweathermap.PointWeatherSummary `json:"current"`
+			}{loc.name, wea.Current})
+		}
+		c := wea.Current
+		fmt.Fprintf(tw, "LOCATION\tTIME\tTEMP\tFEELS LIKE\tHUMIDITY\tWIND\tDESCRIPTION\n")
+		fmt.Fprintf(tw, "%s\t%s\t%.01f%s\t%.01f%s\t%d%%\t%.01f %s\t%s\n",
+			loc.name,
+			time.Unix(c.Dt, 0).In(tz).Format("15:04 MST"),
+			c.Temp, tempUnit,
+			c.FeelsLike, tempUnit,
+			c.Humidity,
+			c.WindSpeed, speedUnit,
+			description(c.CommonWeatherSummary),
+		)
+	case cmdForecast:
+		daily := wea.Daily
+		if opts.days < len(daily) {
+			daily = daily[:opts.days]
+		}
+		if opts.asJSON {
+			return printJSON(w, struct {
+				Name  string                               `json:"name"`
+				Daily []openweathermap.DailyWeatherSummary `json:"daily"`
+			}{loc.name, daily})
+		}
+		fmt.Fprintf(tw, "DATE\tMIN\tMAX\tRAIN\tWIND\tDESCRIPTION\n")
+		for _, d := range daily {
+			fmt.Fprintf(tw, "%s\t%.01f%s\t%.01f%s\t%.0f%%\t%.01f %s\t%s\n",
+				time.Unix(d.Dt, 0).In(tz).Format("Mon Jan 2"),
+				d.Temp.Min, tempUnit,
+				d.Temp.Max, tempUnit,
+				d.Pop*100,
+				d.WindSpeed, speedUnit,
+				description(d.CommonWeatherSummary),
+			)
+		}
+	case cmdHourly:
+		hourly := wea.Hourly
+		if opts.hours < len(hourly) {
+			hourly = hourly[:opts.hours]
+		}
+		if opts.asJSON {
+			return printJSON(w, struct {
+				Name   string                               `json:"name"`
+				Hourly []openweathermap.PointWeatherSummary `json:"hourly"`
+			}{loc.name, hourly})
+		}
+		fmt.Fprintf(tw, "TIME\tTEMP\tFEELS LIKE\tRAIN\tWIND\tDESCRIPTION\n")
+		for _, h := range hourly {
+			fmt.Fprintf(tw, "%s\t%.01f%s\t%.01f%s\t%.0f%%\t%.01f %s\t%s\n",
+				time.Unix(h.Dt, 0).In(tz).Format("Mon 15:04"),
+				h.Temp, tempUnit,
+				h.FeelsLike, tempUnit,
+				h.Pop*100,
+				h.WindSpeed, speedUnit,
+				description(h.CommonWeatherSummary),
+			)
+		}
+	}
+	return tw.Flush()
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// oneShot parses the arguments, loads the configuration and runs a one-shot
+// command, exiting on error.
+func oneShot(cmd string, args []string) {
+	opts, err := parseOneShot(cmd, args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", progname, cmd, err)
+		os.Exit(2)
+	}
+	_, cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config file: %v\n", err)
+		os.Exit(1)
+	}
+	setupAPIClients(cfg)
+	if err := runOneShot(context.Background(), cfg, opts, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", progname, cmd, err)
+		os.Exit(1)
+	}
+}
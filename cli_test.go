@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestParseOneShot(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cmd  string
+		args []string
+		want oneShotOptions
+	}{
+		{"defaults", cmdForecast, nil, oneShotOptions{days: 3}},
+		{"flags before location", cmdNow, []string{"--json", "Dublin"}, oneShotOptions{location: "Dublin", asJSON: true}},
+		{"flags after location", cmdNow, []string{"Dublin", "--json"}, oneShotOptions{location: "Dublin", asJSON: true}},
+		{"flags around location", cmdHourly, []string{"-hours", "48", "New York", "-json"}, oneShotOptions{location: "New York", asJSON: true, hours: 48}},
+		{"location after --", cmdNow, []string{"--", "-json"}, oneShotOptions{location: "-json"}},
+		{"fewest days", cmdForecast, []string{"-days=1"}, oneShotOptions{days: 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseOneShot(tc.cmd, tc.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.want.cmd = tc.cmd
+			if *got != tc.want {
+				t.Errorf("parseOneShot() = %+v, want %+v", *got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseOneShotErrors(t *testing.T) {
+	for _, tc := range []struct {
+		cmd  string
+		args []string
+	}{
+		{cmdNow, []string{"Dublin", "Cork"}},
+		{cmdNow, []string{"Dublin", "--json", "Cork"}},
+		{cmdForecast, []string{"-days", "0"}},
+		{cmdForecast, []string{"Dublin", "-days", "9"}},
+		{cmdHourly, []string{"-hours", "-1"}},
+		{cmdHourly, []string{"-hours", "49"}},
+		{"later", nil},
+	} {
+		if _, err := parseOneShot(tc.cmd, tc.args); err == nil {
+			t.Errorf("parseOneShot(%s, %q) succeeded, want error", tc.cmd, tc.args)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+
+	"github.com/insomniacslk/openweathermap"
+)
+
+// comfort are indices of how the weather feels, derived from the temperature,
+// humidity and wind. All of them but the humidex are temperatures in the
+// configured units.
+type comfort struct {
+	// HeatIndex is the US National Weather Service heat index, which
+	// accounts for humidity in hot weather. It is the same as the
+	// temperature below about 27°C, where it is not defined.
+	HeatIndex float64 `json:"heat_index"`
+	// WindChill is the North American wind chill index. It is the same as
+	// the temperature above 10°C or with wind below 4.8 km/h, where it is
+	// not defined.
+	WindChill float64 `json:"wind_chill"`
+	// Humidex is the Canadian humidex, which accounts for humidity. It is a
+	// dimensionless number, close to the temperature in °C whatever the
+	// units.
+	Humidex float64 `json:"humidex"`
+	// ApparentTemperature is the Australian Bureau of Meteorology apparent
+	// temperature, without solar radiation, which accounts for both
+	// humidity and wind.
+	ApparentTemperature float64 `json:"apparent_temperature"`
+	// DewPoint is the dew point, computed with the Magnus formula.
+	DewPoint float64 `json:"dew_point"`
+}
+
+// dewPoint returns the dew point in °C, given the temperature in °C and the
+// relative humidity in percent.
+func dewPoint(t, rh float64) float64 {
+	const a, b = 17.62, 243.12
+	gamma := math.Log(rh/100) + a*t/(b+t)
+	return b * gamma / (a - gamma)
+}
+
+// heatIndex returns the heat index in °C, given the temperature in °C and the
+// relative humidity in percent, with the algorithm of the US National Weather
+// Service, which works in °F. Below about 80°F it returns the temperature.
+func heatIndex(t, rh float64) float64 {
+	f := t*9/5 + 32
+	if (0.5*(f+61+(f-68)*1.2+rh*0.094)+f)/2 < 80 {
+		return t
+	}
+	hi := -42.379 + 2.04901523*f + 10.14333127*rh -
+		0.22475541*f*rh - 0.00683783*f*f - 0.05481717*rh*rh +
+		0.00122874*f*f*rh + 0.00085282*f*rh*rh - 0.00000199*f*f*rh*rh
+	switch {
+	case rh < 13 && f >= 80 && f <= 112:
+		hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(f-95))/17)
+	case rh > 85 && f >= 80 && f <= 87:
+		hi += (rh - 85) / 10 * (87 - f) / 5
+	}
+	return (hi - 32) * 5 / 9
+}
+
+// windChill returns the wind chill in °C, given the temperature in °C and the
+// wind speed in m/s.
+func windChill(t, wind float64) float64 {
+	kmh := wind * 3.6
+	if t > 10 || kmh <= 4.8 {
+		return t
+	}
+	v := math.Pow(kmh, 0.16)
+	return 13.12 + 0.6215*t - 11.37*v + 0.3965*t*v
+}
+
+// humidex returns the humidex, given the temperature and the dew point in °C.
+func humidex(t, dew float64) float64 {
+	e := 6.11 * math.Exp(5417.7530*(1/273.16-1/(273.15+dew)))
+	return t + 0.5555*(e-10)
+}
+
+// apparentTemperature returns the apparent temperature in °C, given the
+// temperature in °C, the relative humidity in percent and the wind speed in
+// m/s.
+func apparentTemperature(t, rh, wind float64) float64 {
+	// water vapour pressure, in hPa
+	e := rh / 100 * 6.105 * math.Exp(17.27*t/(237.7+t))
+	return t + 0.33*e - 0.70*wind - 4.00
+}
+
+// getComfort computes the comfort indices for the given weather, whose values
+// are in the given units.
+func getComfort(w *openweathermap.PointWeatherSummary, units string) comfort {
+	t := toCelsius(w.Temp, units)
+	// avoid the logarithm of zero in the dew point
+	rh := math.Max(float64(w.Humidity), 1)
+	wind := toMetersPerSecond(w.WindSpeed, units)
+	dew := dewPoint(t, rh)
+	return comfort{
+		HeatIndex:           fromCelsius(heatIndex(t, rh), units),
+		WindChill:           fromCelsius(windChill(t, wind), units),
+		Humidex:             humidex(t, dew),
+		ApparentTemperature: fromCelsius(apparentTemperature(t, rh, wind), units),
+		DewPoint:            fromCelsius(dew, units),
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/insomniacslk/openweathermap"
+)
+
+func TestHeatIndex(t *testing.T) {
+	// from the heat index chart of the US National Weather Service, in °F
+	for _, tc := range []struct {
+		f, rh, want float64
+	}{
+		{80, 40, 80},
+		{90, 70, 106},
+		{96, 65, 121},
+		{100, 40, 109},
+		// with the adjustment for high humidity
+		{86, 90, 105},
+	} {
+		got := heatIndex((tc.f-32)*5/9, tc.rh)*9/5 + 32
+		if math.Round(got) != tc.want {
+			t.Errorf("heat index at %g°F and %g%% = %.1f°F, want %g°F", tc.f, tc.rh, got, tc.want)
+		}
+	}
+}
+
+func TestWindChill(t *testing.T) {
+	// from the wind chill chart of Environment Canada, in °C and km/h
+	for _, tc := range []struct {
+		t, kmh, want float64
+	}{
+		{5, 10, 3},
+		{-10, 20, -18},
+		{-20, 30, -33},
+		{-30, 50, -49},
+		// not defined above 10°C or with almost no wind
+		{15, 30, 15},
+		{-10, 3, -10},
+	} {
+		got := windChill(tc.t, tc.kmh/3.6)
+		if math.Round(got) != tc.want {
+			t.Errorf("wind chill at %g°C and %g km/h = %.1f°C, want %g°C", tc.t, tc.kmh, got, tc.want)
+		}
+	}
+}
+
+func TestHumidex(t *testing.T) {
+	// from the humidex table of Environment Canada, in °C
+	for _, tc := range []struct {
+		t, dew, want float64
+	}{
+		{25, 20, 33},
+		{30, 15, 34},
+		{35, 25, 47},
+	} {
+		got := humidex(tc.t, tc.dew)
+		if math.Round(got) != tc.want {
+			t.Errorf("humidex at %g°C with dew point %g°C = %.1f, want %g", tc.t, tc.dew, got, tc.want)
+		}
+	}
+}
+
+func TestDewPoint(t *testing.T) {
+	for _, tc := range []struct {
+		t, rh, want float64
+	}{
+		{10, 100, 10},
+		{25, 60, 16.7},
+		{30, 30, 10.5},
+	} {
+		if got := dewPoint(tc.t, tc.rh); math.Abs(got-tc.want) > 0.1 {
+			t.Errorf("dew point at %g°C and %g%% = %.2f°C, want %g°C", tc.t, tc.rh, got, tc.want)
+		}
+	}
+}
+
+func TestGetComfortUnits(t *testing.T) {
+	// the same weather in each units gives the same indices
+	var metric openweathermap.PointWeatherSummary
+	metric.Temp = -10
+	metric.Humidity = 80
+	metric.WindSpeed = 20 / 3.6
+	imperial, standard := metric, metric
+	imperial.Temp, imperial.WindSpeed = 14, metric.WindSpeed/0.44704
+	standard.Temp = 263.15
+	want := getComfort(&metric, "metric")
+	if math.Round(want.WindChill) != -18 {
+		t.Fatalf("wind chill = %.1f°C, want -18°C", want.WindChill)
+	}
+	for _, tc := range []struct {
+		units string
+		w     *openweathermap.PointWeatherSummary
+	}{
+		{"imperial", &imperial},
+		{"standard", &standard},
+	} {
+		got := getComfort(tc.w, tc.units)
+		// the humidex is dimensionless, so the same in all units
+		if diff := got.Humidex - want.Humidex; math.Abs(diff) > 0.01 {
+			t.Errorf("%s humidex is off by %.2f", tc.units, diff)
+		}
+		for _, c := range []struct {
+			name      string
+			got, want float64
+		}{
+			{"heat index", got.HeatIndex, want.HeatIndex},
+			{"wind chill", got.WindChill, want.WindChill},
+			{"apparent temperature", got.ApparentTemperature, want.ApparentTemperature},
+			{"dew point", got.DewPoint, want.DewPoint},
+		} {
+			if diff := toCelsius(c.got, tc.units) - c.want; math.Abs(diff) > 0.01 {
+				t.Errorf("%s %s is off by %.2f°C", tc.units, c.name, diff)
+			}
+		}
+	}
+}
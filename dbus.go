@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// D-Bus name, object path and interface of the service.
+const (
+	dbusName      = "org.wea.Weather"
+	dbusPath      = dbus.ObjectPath("/org/wea/Weather")
+	dbusInterface = "org.wea.Weather"
+)
+
+// dbusIntrospection describes the service's interface.
+var dbusIntrospection = &introspect.Node{
+	Name: string(dbusPath),
+	Interfaces: []introspect.Interface{
+		introspect.IntrospectData,
+		{
+			Name: dbusInterface,
+			Methods: []introspect.Method{
+				{Name: "Refresh"},
+				{Name: "ReloadConfig"},
+				{
+					Name: "GetCurrent",
+					Args: []introspect.Arg{
+						{Name: "location", Type: "s", Direction: "in"},
+						{Name: "weather", Type: "s", Direction: "out"},
+					},
+				},
+				{
+					Name: "ListLocations",
+					Args: []introspect.Arg{
+						{Name: "locations", Type: "as", Direction: "out"},
+					},
+				},
+			},
+			Signals: []introspect.Signal{
+				{
+					Name: "WeatherUpdated",
+					Args: []introspect.Arg{
+						{Name: "timestamp", Type: "x"},
+					},
+				},
+			},
+		},
+	},
+}
+
+// replyHooks runs functions once the replies to some method calls have been
+// sent.
+type replyHooks struct {
+	conn *dbus.Conn
+	mu   sync.Mutex
+	// calls maps the method calls waiting for their reply to the functions
+	// to run once it is sent.
+	calls map[pendingCall]func()
+}
+
+// pendingCall identifies a method call by its sender and serial, since
+// serials are only unique per sender.
+type pendingCall struct {
+	sender string
+	serial uint32
+}
+
+func newReplyHooks() *replyHooks {
+	return &replyHooks{calls: make(map[pendingCall]func())}
+}
+
+// After registers fn to run once the reply to the given method call has been
+// sent, or right away if the caller does not expect a reply.
+func (h *replyHooks) After(call *dbus.Message, fn func()) {
+	if call.Flags&dbus.FlagNoReplyExpected != 0 {
+		go fn()
+		return
+	}
+	sender, _ := call.Headers[dbus.FieldSender].Value().(string)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls[pendingCall{sender, call.Serial()}] = fn
+}
+
+// intercept is called with every outgoing message, right before it is sent.
+func (h *replyHooks) intercept(msg *dbus.Message) {
+	if msg.Type != dbus.TypeMethodReply && msg.Type != dbus.TypeError {
+		return
+	}
+	dest, _ := msg.Headers[dbus.FieldDestination].Value().(string)
+	serial, _ := msg.Headers[dbus.FieldReplySerial].Value().(uint32)
+	key := pendingCall{dest, serial}
+	h.mu.Lock()
+	fn, ok := h.calls[key]
+	delete(h.calls, key)
+	h.mu.Unlock()
+	if !ok {
+		return
+	}
+	go func() {
+		// messages are sent in order, so the reply is out once the bus
+		// answers a call sent after it
+		if err := h.conn.BusObject().Call("org.freedesktop.DBus.Peer.Ping", 0).Err; err != nil {
+			log.Printf("Failed to ping the session bus: %v", err)
+		}
+		fn()
+	}()
+}
+
+// dbusService exposes the running app on the session bus.
+type dbusService struct {
+	cache   *weatherCache
+	refresh func()
+	reload  func() (func(), error)
+	hooks   *replyHooks
+}
+
+// Refresh starts a weather update.
+func (s *dbusService) Refresh() *dbus.Error {
+	s.refresh()
+	return nil
+}
+
+// ReloadConfig restarts the app with the current configuration file, if it is
+// valid. The restart happens once the reply has been sent.
+func (s *dbusService) ReloadConfig(msg dbus.Message) *dbus.Error {
+	after, err := s.reload()
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	s.hooks.After(&msg, after)
+	return nil
+}
+
+// GetCurrent returns the latest weather for the given location as a JSON
+// object. An empty location returns the weather for the current location.
+func (s *dbusService) GetCurrent(location string) (string, *dbus.Error) {
+	e, ok := s.cache.Get(location)
+	if !ok {
+		return "", dbus.MakeFailedError(fmt.Errorf("unknown location '%s'", location))
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return string(data), nil
+}
+
+// ListLocations returns the names of the configured locations.
+func (s *dbusService) ListLocations() ([]string, *dbus.Error) {
+	var names []string
+	for _, e := range s.cache.Locations() {
+		names = append(names, e.Name)
+	}
+	return names, nil
+}
+
+// startDBusService exports the service on the session bus, and emits the
+// WeatherUpdated signal after every update. Closing the returned connection
+// stops the service.
+func startDBusService(cache *weatherCache, refresh func(), reload func() (func(), error)) (*dbus.Conn, error) {
+	hooks := newReplyHooks()
+	conn, err := dbus.ConnectSessionBus(dbus.WithOutgoingInterceptor(hooks.intercept))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the session bus: %w", err)
+	}
+	hooks.conn = conn
+	s := dbusService{cache: cache, refresh: refresh, reload: reload, hooks: hooks}
+	if err := conn.Export(&s, dbusPath, dbusInterface); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to export service: %w", err)
+	}
+	if err := conn.Export(introspect.NewIntrospectable(dbusIntrospection), dbusPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to export introspection: %w", err)
+	}
+	reply, err := conn.RequestName(dbusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to request name '%s': %w", dbusName, err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Close()
+		return nil, fmt.Errorf("name '%s' is already taken", dbusName)
+	}
+	cache.OnUpdate(func() {
+		if err := conn.Emit(dbusPath, dbusInterface+".WeatherUpdated", time.Now().Unix()); err != nil {
+			log.Printf("Failed to emit WeatherUpdated signal: %v", err)
+		}
+	})
+	return conn, nil
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/openweathermap"
+)
+
+// cmdExport prints the observations and forecasts stored in the history.
+const cmdExport = "export"
+
+// exportFormats are the formats supported by the export command.
+var exportFormats = []string{"csv", "jsonl", "parquet"}
+
+// exportRow is a row of the export: an observation, or one hour of the
+// forecast recorded with it.
+type exportRow struct {
+	kind string
+	o    *observation
+	p    *openweathermap.PointWeatherSummary
+}
+
+// optional returns the value pointed to by v, or nil.
+func optional(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+// exportColumns are the columns of the export, in order.
+var exportColumns = []struct {
+	parquetColumn
+	value func(r *exportRow) interface{}
+}{
+	{parquetColumn{"kind", parquetString}, func(r *exportRow) interface{} { return r.kind }},
+	{parquetColumn{"location", parquetString}, func(r *exportRow) interface{} { return r.o.Location }},
+	{parquetColumn{"provider", parquetString}, func(r *exportRow) interface{} {
+		if r.o.Provider == "" {
+			return providerOpenWeatherMap
+		}
+		return r.o.Provider
+	}},
+	{parquetColumn{"lat", parquetDouble}, func(r *exportRow) interface{} { return r.o.Lat }},
+	{parquetColumn{"lon", parquetDouble}, func(r *exportRow) interface{} { return r.o.Lon }},
+	// time is the time the weather refers to, issued the time it was
+	// recorded, and lead_hours how far ahead the forecast was.
+	{parquetColumn{"time", parquetTimestamp}, func(r *exportRow) interface{} { return time.Unix(r.p.Dt, 0).UTC() }},
+	{parquetColumn{"issued", parquetTimestamp}, func(r *exportRow) interface{} { return r.o.Time.UTC() }},
+	{parquetColumn{"lead_hours", parquetInt64}, func(r *exportRow) interface{} {
+		return int64(math.Round(time.Unix(r.p.Dt, 0).Sub(r.o.Time).Hours()))
+	}},
+	{parquetColumn{"units", parquetString}, func(r *exportRow) interface{} { return r.o.Units }},
+	{parquetColumn{"temp", parquetDouble}, func(r *exportRow) interface{} { return r.p.Temp }},
+	{parquetColumn{"feels_like", parquetDouble}, func(r *exportRow) interface{} { return r.p.FeelsLike }},
+	{parquetColumn{"dew_point", parquetDouble}, func(r *exportRow) interface{} { return r.p.DewPoint }},
+	{parquetColumn{"humidity", parquetInt64}, func(r *exportRow) interface{} { return int64(r.p.Humidity) }},
+	{parquetColumn{"pressure", parquetInt64}, func(r *exportRow) interface{} { return int64(r.p.Pressure) }},
+	{parquetColumn{"clouds", parquetInt64}, func(r *exportRow) interface{} { return int64(r.p.Clouds) }},
+	{parquetColumn{"uvi", parquetDouble}, func(r *exportRow) interface{} { return r.p.UVI }},
+	{parquetColumn{"visibility", parquetInt64}, func(r *exportRow) interface{} { return int64(r.p.Visibility) }},
+	{parquetColumn{"wind_speed", parquetDouble}, func(r *exportRow) interface{} { return r.p.WindSpeed }},
+	{parquetColumn{"wind_deg", parquetInt64}, func(r *exportRow) interface{} { return int64(r.p.WindDeg) }},
+	{parquetColumn{"wind_gust", parquetDouble}, func(r *exportRow) interface{} { return optional(r.p.WindGust) }},
+	// the probability of precipitation is only forecast
+	{parquetColumn{"pop", parquetDouble}, func(r *exportRow) interface{} {
+		if r.kind != "forecast" {
+			return nil
+		}
+		return r.p.Pop
+	}},
+	{parquetColumn{"rain_1h", parquetDouble}, func(r *exportRow) interface{} { return optional(r.p.Rain.OneHour) }},
+	{parquetColumn{"snow_1h", parquetDouble}, func(r *exportRow) interface{} { return optional(r.p.Snow.OneHour) }},
+	{parquetColumn{"condition", parquetString}, func(r *exportRow) interface{} {
+		if len(r.p.Weather) == 0 {
+			return nil
+		}
+		return r.p.Weather[0].Main
+	}},
+	{parquetColumn{"description", parquetString}, func(r *exportRow) interface{} { return description(r.p.CommonWeatherSummary) }},
+	// air quality is only observed
+	{parquetColumn{"aqi", parquetInt64}, func(r *exportRow) interface{} {
+		if r.kind != "observation" || r.o.AirQuality == nil {
+			return nil
+		}
+		return int64(r.o.AirQuality.AQI)
+	}},
+	{parquetColumn{"pm2_5", parquetDouble}, func(r *exportRow) interface{} {
+		if r.kind != "observation" || r.o.AirQuality == nil {
+			return nil
+		}
+		return r.o.AirQuality.PM25
+	}},
+	{parquetColumn{"pm10", parquetDouble}, func(r *exportRow) interface{} {
+		if r.kind != "observation" || r.o.AirQuality == nil {
+			return nil
+		}
+		return r.o.AirQuality.PM10
+	}},
+	{parquetColumn{"o3", parquetDouble}, func(r *exportRow) interface{} {
+		if r.kind != "observation" || r.o.AirQuality == nil {
+			return nil
+		}
+		return r.o.AirQuality.O3
+	}},
+	{parquetColumn{"no2", parquetDouble}, func(r *exportRow) interface{} {
+		if r.kind != "observation" || r.o.AirQuality == nil {
+			return nil
+		}
+		return r.o.AirQuality.NO2
+	}},
+}
+
+// values returns the values of the row, one per column.
+func (r *exportRow) values() []interface{} {
+	values := make([]interface{}, 0, len(exportColumns))
+	for _, c := range exportColumns {
+		values = append(values, c.value(r))
+	}
+	return values
+}
+
+// formatValue formats a value for CSV. Times are in UTC, nulls are empty.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return ""
+}
+
+// rowWriter writes export rows in one of exportFormats.
+type rowWriter interface {
+	Write(values []interface{}) error
+	Close() error
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	c := csvWriter{w: csv.NewWriter(w)}
+	header := make([]string, 0, len(exportColumns))
+	for _, col := range exportColumns {
+		header = append(header, col.name)
+	}
+	return &c, c.w.Write(header)
+}
+
+func (c *csvWriter) Write(values []interface{}) error {
+	record := make([]string, 0, len(values))
+	for _, v := range values {
+		record = append(record, formatValue(v))
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonlWriter writes one JSON object per row, with the keys in the order of
+// the columns.
+type jsonlWriter struct {
+	w *bufio.Writer
+}
+
+func (j *jsonlWriter) Write(values []interface{}) error {
+	j.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(exportColumns[i].name)
+		value, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("column '%s': %w", exportColumns[i].name, err)
+		}
+		j.w.Write(key)
+		j.w.WriteByte(':')
+		j.w.Write(value)
+	}
+	j.w.WriteString("}\n")
+	return nil
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.w.Flush()
+}
+
+// exportOptions are the options of the export command.
+type exportOptions struct {
+	location string
+	from, to time.Time
+	format   string
+	output   string
+}
+
+// parseDate parses a local date like 2026-01-01, or a time in RFC 3339 format.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date '%s', must be like 2026-01-01 or 2026-01-01T08:00:00Z", s)
+	}
+	return t, nil
+}
+
+// parseExport parses the arguments of the export command.
+func parseExport(args []string) (*exportOptions, error) {
+	var (
+		opts     exportOptions
+		from, to string
+	)
+	fs := flag.NewFlagSet(cmdExport, flag.ContinueOnError)
+	fs.StringVar(&opts.location, "location", "", "Only export this location, e.g. Dublin. Defaults to all locations")
+	fs.StringVar(&from, "from", "", "Only export what was recorded from this date, e.g. 2026-01-01")
+	fs.StringVar(&to, "to", "", "Only export what was recorded before this date, e.g. 2026-02-01")
+	fs.StringVar(&opts.format, "format", "csv", "Output format, one of "+strings.Join(exportFormats, ", "))
+	fs.StringVar(&opts.output, "output", "", "Write to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n\nExport the observations and forecasts recorded in the history, one row per observation and per hour of forecast.\n\nFlags:\n", progname, cmdExport)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	found := false
+	for _, f := range exportFormats {
+		found = found || f == opts.format
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown format '%s', must be one of %s", opts.format, strings.Join(exportFormats, ", "))
+	}
+	var err error
+	if from != "" {
+		if opts.from, err = parseDate(from); err != nil {
+			return nil, fmt.Errorf("-from: %w", err)
+		}
+	}
+	if to != "" {
+		if opts.to, err = parseDate(to); err != nil {
+			return nil, fmt.Errorf("-to: %w", err)
+		}
+	}
+	return &opts, nil
+}
+
+// matchLocation returns true if the location of an observation, as returned by
+// geocoding like "Dublin, Ireland", matches the requested name, like "Dublin".
+func matchLocation(location, name string) bool {
+	location, name = strings.ToLower(location), strings.ToLower(name)
+	return name == "" || location == name || strings.HasPrefix(location, name+",")
+}
+
+// runExport writes the history matching the options to w, and returns the
+// number of rows.
+func runExport(file string, opts *exportOptions, w io.Writer) (int, error) {
+	var rw rowWriter
+	switch opts.format {
+	case "csv":
+		c, err := newCSVWriter(w)
+		if err != nil {
+			return 0, err
+		}
+		rw = c
+	case "jsonl":
+		rw = &jsonlWriter{w: bufio.NewWriter(w)}
+	case "parquet":
+		cols := make([]parquetColumn, 0, len(exportColumns))
+		for _, c := range exportColumns {
+			cols = append(cols, c.parquetColumn)
+		}
+		rw = newParquetWriter(w, cols)
+	}
+	rows := 0
+	err := readHistory(file, func(o *observation) error {
+		if !matchLocation(o.Location, opts.location) ||
+			!opts.from.IsZero() && o.Time.Before(opts.from) ||
+			!opts.to.IsZero() && !o.Time.Before(opts.to) {
+			return nil
+		}
+		if o.Current != nil {
+			if err := rw.Write((&exportRow{kind: "observation", o: o, p: o.Current}).values()); err != nil {
+				return err
+			}
+			rows++
+		}
+		for i := range o.Hourly {
+			if err := rw.Write((&exportRow{kind: "forecast", o: o, p: &o.Hourly[i]}).values()); err != nil {
+				return err
+			}
+			rows++
+		}
+		return nil
+	})
+	if err != nil {
+		return rows, err
+	}
+	return rows, rw.Close()
+}
+
+// export parses the arguments and runs the export command, exiting on error.
+func export(args []string) {
+	opts, err := parseExport(args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", progname, cmdExport, err)
+		os.Exit(2)
+	}
+	file, err := historyFile()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: cannot find history: %v\n", progname, cmdExport, err)
+		os.Exit(1)
+	}
+	out := os.Stdout
+	if opts.output != "" {
+		if out, err = os.Create(opts.output); err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: %v\n", progname, cmdExport, err)
+			os.Exit(1)
+		}
+	}
+	rows, err := runExport(file, opts, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", progname, cmdExport, err)
+		os.Exit(1)
+	}
+	if rows == 0 {
+		fmt.Fprintf(os.Stderr, "%s %s: nothing to export, is history enabled in the configuration file?\n", progname, cmdExport)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"image"
 	"image/color"
 	"image/jpeg"
+	"image/png"
 	"log"
+	"sync"
 )
 
 type GraphStyle int
@@ -34,6 +36,7 @@ func NewGraph(W, H int, FG, BG *color.RGBA, style GraphStyle) *Graph {
 }
 
 type Graph struct {
+	mu    sync.Mutex
 	icon  *image.RGBA
 	W     int
 	H     int
@@ -43,6 +46,8 @@ type Graph struct {
 }
 
 func (g *Graph) Blank() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	for x := 0; x < g.W; x++ {
 		g.BlankVLine(x)
 	}
@@ -55,6 +60,8 @@ func (g *Graph) BlankVLine(x int) {
 }
 
 func (g *Graph) SetNext(v int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	g.Scroll()
 	g.VLine(v)
 }
@@ -93,9 +100,21 @@ func (g *Graph) Scroll() {
 }
 
 func (g *Graph) ToIcon() ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	var buf bytes.Buffer
 	if err := jpeg.Encode(&buf, g.icon, nil); err != nil {
 		return nil, fmt.Errorf("failed to encode JPEG: %w", err)
 	}
 	return buf.Bytes(), nil
 }
+
+func (g *Graph) ToPNG() ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, g.icon); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
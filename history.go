@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/openweathermap"
+	"github.com/kirsle/configdir"
+)
+
+// defaultHistoryDays is how many days of observations are kept by default.
+const defaultHistoryDays = 90
+
+// forecastInterval is how often the hourly forecast of a location is recorded
+// with its observation. Forecasts are much larger than observations, and
+// barely change between updates.
+const forecastInterval = time.Hour
+
+// observation is the weather observed at a location at a given time, as
+// stored in the history.
+type observation struct {
+	Location string    `json:"location"`
+	Lat      float64   `json:"lat"`
+	Lon      float64   `json:"lon"`
+	Time     time.Time `json:"time"`
+	// Provider is where the weather comes from. Observations recorded
+	// before it was added have none, and come from OpenWeatherMap.
+	Provider string `json:"provider,omitempty"`
+	// Units are the units of the weather, i.e. the units configured when
+	// the observation was recorded.
+	Units      string                              `json:"units"`
+	Current    *openweathermap.PointWeatherSummary `json:"current"`
+	AirQuality *airQuality                         `json:"air_quality,omitempty"`
+	// Hourly is the hourly forecast available at the time of the
+	// observation, recorded at most every forecastInterval.
+	Hourly []openweathermap.PointWeatherSummary `json:"hourly,omitempty"`
+}
+
+// history is an append-only database of observations, stored as one JSON
+// object per line in history.jsonl in the config directory. Observations
+// older than the retention period are pruned once a day.
+type history struct {
+	mu        sync.Mutex
+	file      string
+	units     string
+	retention time.Duration
+	// last is the time of the latest observation recorded for each
+	// location, so that the same observation is not recorded twice.
+	last map[string]time.Time
+	// lastForecast is the time of the latest forecast recorded for each
+	// location.
+	lastForecast map[string]time.Time
+	lastPrune    time.Time
+}
+
+// historyFile returns the path of the history file.
+func historyFile() (string, error) {
+	configPath := configdir.LocalConfig(progname)
+	if err := configdir.MakePath(configPath); err != nil {
+		return "", err
+	}
+	return path.Join(configPath, "history.jsonl"), nil
+}
+
+// openHistory opens the history, and prunes the old observations.
+func openHistory(cfg *Config) (*history, error) {
+	file, err := historyFile()
+	if err != nil {
+		return nil, err
+	}
+	h := history{
+		file:         file,
+		units:        cfg.Units,
+		retention:    time.Duration(cfg.HistoryDays) * 24 * time.Hour,
+		last:         make(map[string]time.Time),
+		lastForecast: make(map[string]time.Time),
+	}
+	if err := h.prune(time.Now()); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// readHistory calls fn for every observation in the history file, in the
+// order they were recorded. A missing file is not an error.
+func readHistory(file string, fn func(o *observation) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var o observation
+		if err := json.Unmarshal(scanner.Bytes(), &o); err != nil {
+			log.Printf("Skipping invalid observation at %s:%d: %v", file, line, err)
+			continue
+		}
+		if err := fn(&o); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// prune rewrites the history file without the observations older than the
+// retention period. Must be called with the lock held.
+func (h *history) prune(now time.Time) error {
+	h.lastPrune = now
+	cutoff := now.Add(-h.retention)
+	tmp := h.file + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	kept, pruned := 0, 0
+	err = readHistory(h.file, func(o *observation) error {
+		if o.Time.Before(cutoff) {
+			pruned++
+			return nil
+		}
+		kept++
+		if o.Time.After(h.last[o.Location]) {
+			h.last[o.Location] = o.Time
+		}
+		if len(o.Hourly) > 0 && o.Time.After(h.lastForecast[o.Location]) {
+			h.lastForecast[o.Location] = o.Time
+		}
+		return enc.Encode(o)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to prune history: %w", err)
+	}
+	if pruned == 0 {
+		// nothing changed, leave the file alone
+		return os.Remove(tmp)
+	}
+	log.Printf("Pruned %d observation(s) older than %s from the history, %d left", pruned, cutoff.Format(time.RFC3339), kept)
+	return os.Rename(tmp, h.file)
+}
+
+// Record appends the observations of the given cache entries to the history,
+// skipping those that failed or were already recorded, with their hourly
+// forecast if the last one recorded is older than forecastInterval.
+func (h *history) Record(entries []*cacheEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if now.Sub(h.lastPrune) >= 24*time.Hour {
+		if err := h.prune(now); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if e == nil || e.Weather == nil || e.Weather.Current == nil || e.Error != "" {
+			continue
+		}
+		t := time.Unix(e.Weather.Current.Dt, 0)
+		if !t.After(h.last[e.Name]) {
+			continue
+		}
+		o := observation{
+			Location:   e.Name,
+			Provider:   providerOpenWeatherMap,
+			Lat:        e.Lat,
+			Lon:        e.Lon,
+			Time:       t,
+			Units:      h.units,
+			Current:    e.Weather.Current,
+			AirQuality: e.AirQuality,
+		}
+		if t.Sub(h.lastForecast[e.Name]) >= forecastInterval {
+			o.Hourly = e.Weather.Hourly
+		}
+		if err := enc.Encode(&o); err != nil {
+			f.Close()
+			return err
+		}
+		h.last[e.Name] = t
+		if len(o.Hourly) > 0 {
+			h.lastForecast[e.Name] = t
+		}
+	}
+	return f.Close()
+}
+
+// Watch records the observations in the cache after every update.
+func (h *history) Watch(cache *weatherCache) {
+	cache.OnUpdate(func() {
+		entries := []*cacheEntry{cache.Current()}
+		locs := cache.Locations()
+		for i := range locs {
+			entries = append(entries, &locs[i])
+		}
+		if err := h.Record(entries); err != nil {
+			log.Printf("Failed to record history: %v", err)
+		}
+	})
+}
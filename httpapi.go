@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixPrefix is the prefix of http_listen for a Unix socket.
+const unixPrefix = "unix:"
+
+// listenLocal listens on the given address, which is either a Unix socket path
+// prefixed by "unix:", or a host:port pair where the host must be a loopback
+// address. The API is not meant to be reachable from other machines.
+func listenLocal(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		socketPath := strings.TrimPrefix(addr, unixPrefix)
+		if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+		return net.Listen("unix", socketPath)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("refusing to listen on non-loopback address '%s'", host)
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// writeJSON sends v as a JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("Failed to encode HTTP response: %v", err)
+	}
+}
+
+// newAPIHandler returns the handler for the HTTP API, serving data from the
+// cache. g may be nil if the graph is disabled.
+func newAPIHandler(cache *weatherCache, g *Graph) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/locations", func(w http.ResponseWriter, r *http.Request) {
+		type loc struct {
+			Name string  `json:"name"`
+			Lat  float64 `json:"lat"`
+			Lon  float64 `json:"lon"`
+		}
+		locs := []loc{}
+		for _, e := range cache.Locations() {
+			locs = append(locs, loc{Name: e.Name, Lat: e.Lat, Lon: e.Lon})
+		}
+		writeJSON(w, locs)
+	})
+	mux.HandleFunc("/v1/weather/", func(w http.ResponseWriter, r *http.Request) {
+		name, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/v1/weather/"))
+		if err != nil || name == "" {
+			http.Error(w, "invalid location", http.StatusBadRequest)
+			return
+		}
+		e, ok := cache.Get(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown location '%s'", name), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, e)
+	})
+	mux.HandleFunc("/v1/current", func(w http.ResponseWriter, r *http.Request) {
+		e := cache.Current()
+		if e == nil {
+			http.Error(w, "current location not known yet", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, e)
+	})
+	mux.HandleFunc("/v1/graph.png", func(w http.ResponseWriter, r *http.Request) {
+		if g == nil {
+			http.Error(w, "the graph is disabled, see show_graph", http.StatusNotFound)
+			return
+		}
+		data, err := g.ToPNG()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		if _, err := w.Write(data); err != nil {
+			log.Printf("Failed to write HTTP response: %v", err)
+		}
+	})
+	return mux
+}
+
+// startHTTPServer serves the HTTP API on cfg.HTTPListen until ctx is done, or
+// the returned server is shut down.
+func startHTTPServer(ctx context.Context, cfg *Config, handler http.Handler) (*http.Server, error) {
+	l, err := listenLocal(cfg.HTTPListen)
+	if err != nil {
+		return nil, err
+	}
+	srv := http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down HTTP server: %v", err)
+		}
+	}()
+	go func() {
+		log.Printf("Serving HTTP API on %s", cfg.HTTPListen)
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP server failed: %v", err)
+		}
+	}()
+	return &srv, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// errAlreadyRunning is returned by acquireInstance if another wea process is
+// running for the same user.
+var errAlreadyRunning = errors.New("another instance is already running")
+
+// commands that can be forwarded to the running instance.
+const (
+	cmdRefresh = "refresh"
+	cmdReload  = "reload"
+	// cmdWatch is sent by status bars, which are then streamed the weather
+	// instead of getting a reply.
+	cmdWatch = "watch"
+)
+
+// runtimePath returns the path of a per-user runtime file with the given
+// suffix, e.g. the instance lock or socket.
+func runtimePath(suffix string) string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return path.Join(dir, progname+suffix)
+	}
+	// the temporary directory may be shared between users
+	return path.Join(os.TempDir(), fmt.Sprintf("%s-%d%s", progname, os.Getuid(), suffix))
+}
+
+// instance is the lock held by the running wea process, together with the
+// Unix socket that other invocations use to forward commands to it.
+type instance struct {
+	lock     *os.File
+	listener net.Listener
+}
+
+// acquireInstance takes the per-user instance lock and starts listening on the
+// command socket. It returns errAlreadyRunning if another process holds the
+// lock.
+func acquireInstance() (*instance, error) {
+	lock, err := lockFile(runtimePath(".lock"))
+	if err != nil {
+		return nil, err
+	}
+	// holding the lock means that any existing socket is stale
+	socketPath := runtimePath(".sock")
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		lock.Close()
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to listen on '%s': %w", socketPath, err)
+	}
+	return &instance{lock: lock, listener: listener}, nil
+}
+
+// Serve accepts commands on the socket and passes them to handler, until the
+// instance is closed. The handler's error, if any, is sent back to the
+// client. If the handler returns a non-nil function, it is called after the
+// reply is sent. Watch commands are passed to watch instead, which owns the
+// connection until it returns.
+func (i *instance) Serve(handler func(cmd string) (func(), error), watch func(conn net.Conn)) {
+	for {
+		conn, err := i.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Failed to accept connection: %v", err)
+			}
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			if err := conn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+				log.Printf("Failed to set deadline: %v", err)
+				return
+			}
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				log.Printf("Failed to read command: %v", err)
+				return
+			}
+			cmd := strings.TrimSpace(line)
+			if cmd == cmdWatch {
+				// the connection stays open as long as the client wants
+				if err := conn.SetDeadline(time.Time{}); err != nil {
+					log.Printf("Failed to clear deadline: %v", err)
+					return
+				}
+				watch(conn)
+				return
+			}
+			log.Printf("Received command '%s'", cmd)
+			reply := "ok"
+			after, err := handler(cmd)
+			if err != nil {
+				reply = "error: " + err.Error()
+			}
+			if _, err := fmt.Fprintln(conn, reply); err != nil {
+				log.Printf("Failed to reply to command: %v", err)
+			}
+			conn.Close()
+			if after != nil {
+				after()
+			}
+		}(conn)
+	}
+}
+
+// Close stops listening for commands and releases the lock.
+func (i *instance) Close() error {
+	lerr := i.listener.Close()
+	if err := i.lock.Close(); err != nil {
+		return err
+	}
+	return lerr
+}
+
+// sendCommand forwards a command to the running instance and returns an error
+// if there is none or if the command failed.
+func sendCommand(ctx context.Context, cmd string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", runtimePath(".sock"))
+	if err != nil {
+		return fmt.Errorf("is %s running? %w", progname, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintln(conn, cmd); err != nil {
+		return fmt.Errorf("failed to send command: %w", err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read reply: %w", err)
+	}
+	reply = strings.TrimSpace(reply)
+	if reply != "ok" {
+		return errors.New(strings.TrimPrefix(reply, "error: "))
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ERROR_SHARING_VIOLATION, not defined in the syscall package.
+const errorSharingViolation syscall.Errno = 32
+
+// lockFile opens the given file for exclusive access, so that no other process
+// can open it until it is closed or the process exits.
+func lockFile(name string) (*os.File, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(
+		p,
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		0, // no sharing
+		nil,
+		syscall.OPEN_ALWAYS,
+		syscall.FILE_ATTRIBUTE_NORMAL,
+		0,
+	)
+	if err != nil {
+		if errors.Is(err, errorSharingViolation) {
+			return nil, errAlreadyRunning
+		}
+		return nil, err
+	}
+	return os.NewFile(uintptr(h), name), nil
+}
+
+// waitLockFile opens the given file for exclusive access, waiting up to a few
+// seconds for other processes to close it.
+func waitLockFile(name string) (*os.File, error) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f, err := lockFile(name)
+		if !errors.Is(err, errAlreadyRunning) || time.Now().After(deadline) {
+			return f, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -3,19 +3,20 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
-	"os/signal"
 	"path"
-	"syscall"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/getlantern/systray"
 	"github.com/insomniacslk/editor"
 	"github.com/insomniacslk/ipapi"
 	"github.com/insomniacslk/openweathermap"
-	"github.com/insomniacslk/openweathermap/icons"
 	"github.com/insomniacslk/xjson"
 	"github.com/kirsle/configdir"
 	"googlemaps.github.io/maps"
@@ -24,19 +25,73 @@ import (
 const progname = "wea"
 
 func main() {
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGUSR1)
-	updateSignal := make(chan struct{}, 1)
-	go func(us chan<- struct{}) {
-		for {
-			sig := <-signals
-			switch sig {
-			case syscall.SIGUSR1:
-				fmt.Println("Received SIGUSR1, updating weather")
-				us <- struct{}{}
+	catchRefreshSignal()
+	headless := flag.Bool("headless", false, "Run without the tray icon, e.g. on a server or in CI")
+	bar := flag.String("bar", "", "Print the weather to stdout for a status bar, one of "+strings.Join(barFormats, ", ")+". Runs without the tray icon, or shows the weather of the running instance if any")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n\n", progname)
+		fmt.Fprintf(flag.CommandLine.Output(), "Commands, forwarded to the running instance:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\tupdate the weather now\n", cmdRefresh)
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\treload the configuration file\n\n", cmdReload)
+		fmt.Fprintf(flag.CommandLine.Output(), "Commands that print the weather and exit, see '%s <command> -h':\n", progname)
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\tcurrent conditions\n", cmdNow)
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\tdaily forecast\n", cmdForecast)
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\thourly forecast\n\n", cmdHourly)
+		fmt.Fprintf(flag.CommandLine.Output(), "Commands that read the local history, see '%s <command> -h':\n", progname)
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\texport observations and forecasts\n", cmdExport)
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\taccuracy of past forecasts\n\n", cmdAccuracy)
+		fmt.Fprintf(flag.CommandLine.Output(), "Flags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *bar != "" {
+		if err := checkBarFormat(*bar); err != nil {
+			log.Fatalf("Invalid -bar: %v", err)
+		}
+	}
+
+	if flag.NArg() > 0 {
+		cmd := flag.Arg(0)
+		switch cmd {
+		case cmdRefresh, cmdReload:
+			// forwarded to the running instance below
+		case cmdNow, cmdForecast, cmdHourly:
+			oneShot(cmd, flag.Args()[1:])
+			return
+		case cmdExport:
+			export(flag.Args()[1:])
+			return
+		case cmdAccuracy:
+			accuracy(flag.Args()[1:])
+			return
+		default:
+			log.Fatalf("Unknown command '%s', see '%s -h'", cmd, progname)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := sendCommand(ctx, cmd)
+		cancel()
+		if err != nil {
+			log.Fatalf("Command '%s' failed: %v", cmd, err)
+		}
+		return
+	}
+
+	inst, err := acquireInstance()
+	if err != nil {
+		if errors.Is(err, errAlreadyRunning) && *bar != "" {
+			// show the weather of the running instance instead
+			_, cfg, err := loadConfig()
+			if err != nil {
+				log.Fatalf("Failed to open config file: %v", err)
 			}
+			runStatusBarClient(context.Background(), cfg, *bar)
+			return
 		}
-	}(updateSignal)
+		if errors.Is(err, errAlreadyRunning) {
+			log.Fatalf("%s is already running, use '%s %s' or '%s %s' to control it", progname, progname, cmdRefresh, progname, cmdReload)
+		}
+		log.Fatalf("Failed to acquire instance lock: %v", err)
+	}
 
 	configFile, cfg, err := loadConfig()
 	if err != nil {
@@ -51,26 +106,77 @@ func main() {
 			log.Fatalf("Failed to open config file: %v", err)
 		}
 	}
-	systray.Run(
-		func() { onReady(configFile, cfg, updateSignal) },
-		onExit,
-	)
+	setupAPIClients(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	a, err := newApp(ctx, configFile, cfg, inst)
+	if err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+
+	switch {
+	case *bar != "":
+		runStatusBar(ctx, cancel, a, *bar)
+	case *headless:
+		runHeadless(ctx, cancel, a)
+	default:
+		runTray(ctx, cancel, a)
+	}
+}
+
+// setupAPIClients applies the configuration to the API clients, and loads the
+// API usage for the day.
+func setupAPIClients(cfg *Config) {
+	// bound the requests made by API clients that do not accept a context
+	http.DefaultClient.Timeout = time.Duration(cfg.Timeout)
+	var err error
+	apiUsage, err = loadUsage()
+	if err != nil {
+		log.Printf("Failed to load API usage, API calls will not be accounted for: %v", err)
+	}
 }
 
 // Config contains the program's configuration.
 type Config struct {
-	Locations            []string       `json:"locations"`
-	GoogleMapsAPIKey     string         `json:"googlemaps_api_key"`
-	OpenweathermapAPIKey string         `json:"openweathermap_api_key"`
-	Interval             xjson.Duration `json:"interval"`
-	Language             string         `json:"language"`
-	Units                string         `json:"units"`
-	ShowGraph            bool           `json:"show_graph"`
-	Debug                bool           `json:"debug"`
-	Editor               string         `json:"editor"`
-	EditorArgs           []string       `json:"editor_args"`
+	Locations            []string          `json:"locations"`
+	GoogleMapsAPIKey     string            `json:"googlemaps_api_key"`
+	OpenweathermapAPIKey string            `json:"openweathermap_api_key"`
+	Interval             xjson.Duration    `json:"interval"`
+	Language             string            `json:"language"`
+	Units                string            `json:"units"`
+	ShowGraph            bool              `json:"show_graph"`
+	Debug                bool              `json:"debug"`
+	Editor               string            `json:"editor"`
+	EditorArgs           []string          `json:"editor_args"`
+	Concurrency          int               `json:"concurrency"`
+	Timeout              xjson.Duration    `json:"timeout"`
+	DailyBudget          map[string]int    `json:"daily_budget"`
+	AlignUpdates         bool              `json:"align_updates"`
+	HTTPListen           string            `json:"http_listen"`
+	TitleTemplate        string            `json:"title_template"`
+	TooltipTemplate      string            `json:"tooltip_template"`
+	ItemTemplate         string            `json:"item_template"`
+	AirQuality           bool              `json:"air_quality"`
+	AQIThreshold         int               `json:"aqi_threshold"`
+	MoonOverlay          bool              `json:"moon_overlay"`
+	Rules                []Rule            `json:"rules"`
+	QuietHours           map[string]string `json:"quiet_hours"`
+	RespectDND           bool              `json:"respect_dnd"`
+	Briefing             *BriefingConfig   `json:"briefing"`
+	History              bool              `json:"history"`
+	HistoryDays          int               `json:"history_days"`
+
+	// templates are parsed from the *Template fields.
+	templates *templates
+	// quietHours are parsed from QuietHours.
+	quietHours quietHours
 }
 
+// default values for optional configuration fields.
+const (
+	defaultConcurrency = 4
+	defaultTimeout     = 30 * time.Second
+)
+
 func loadConfig() (string, *Config, error) {
 	cfg := Config{}
 
@@ -98,6 +204,61 @@ func loadConfig() (string, *Config, error) {
 	if cfg.GoogleMapsAPIKey == "" {
 		return configFile, nil, fmt.Errorf("googlemaps_api_key cannot be empty")
 	}
+	if cfg.Interval < 0 {
+		return configFile, nil, fmt.Errorf("interval cannot be negative")
+	}
+	if cfg.Concurrency < 0 {
+		return configFile, nil, fmt.Errorf("concurrency cannot be negative")
+	}
+	if cfg.Timeout < 0 {
+		return configFile, nil, fmt.Errorf("timeout cannot be negative")
+	}
+	if cfg.HistoryDays < 0 {
+		return configFile, nil, fmt.Errorf("history_days cannot be negative")
+	}
+	for provider, budget := range cfg.DailyBudget {
+		if provider != providerOpenWeatherMap && provider != providerGoogleMaps {
+			return configFile, nil, fmt.Errorf("daily_budget: unknown provider '%s'", provider)
+		}
+		if budget < 0 {
+			return configFile, nil, fmt.Errorf("daily_budget for %s cannot be negative", provider)
+		}
+	}
+
+	if cfg.AQIThreshold < 0 || cfg.AQIThreshold > len(aqiLevels) {
+		return configFile, nil, fmt.Errorf("aqi_threshold must be between 1 and %d, or 0 to disable it", len(aqiLevels))
+	}
+	if cfg.AQIThreshold > 0 && !cfg.AirQuality {
+		return configFile, nil, fmt.Errorf("aqi_threshold requires air_quality to be enabled")
+	}
+	for i := range cfg.Rules {
+		if err := cfg.Rules[i].check(&cfg); err != nil {
+			return configFile, nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	if cfg.Briefing != nil {
+		if err := cfg.Briefing.check(&cfg); err != nil {
+			return configFile, nil, fmt.Errorf("briefing: %w", err)
+		}
+	}
+	if cfg.quietHours, err = parseQuietHours(cfg.QuietHours); err != nil {
+		return configFile, nil, fmt.Errorf("quiet_hours: %w", err)
+	}
+	cfg.templates, err = parseTemplates(&cfg)
+	if err != nil {
+		return configFile, nil, err
+	}
+
+	// defaults
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = xjson.Duration(defaultTimeout)
+	}
+	if cfg.HistoryDays == 0 {
+		cfg.HistoryDays = defaultHistoryDays
+	}
 
 	return configFile, &cfg, nil
 }
@@ -107,7 +268,19 @@ type location struct {
 	lat, lon float64
 }
 
-func getLocation(cfg *Config, locName string) (*location, error) {
+// geocodeCache maps location names to geocoded locations, so that each
+// location is geocoded only once.
+var geocodeCache sync.Map
+
+func getLocation(ctx context.Context, cfg *Config, locName string) (*location, error) {
+	if loc, ok := geocodeCache.Load(locName); ok {
+		geocodeCacheHitsTotal.Inc("")
+		return loc.(*location), nil
+	}
+	geocodeCacheMissTotal.Inc("")
+	if err := apiUsage.Reserve(cfg, providerGoogleMaps); err != nil {
+		return nil, err
+	}
 	client, err := maps.NewClient(maps.WithAPIKey(cfg.GoogleMapsAPIKey))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Maps client: %w", err)
@@ -115,7 +288,10 @@ func getLocation(cfg *Config, locName string) (*location, error) {
 	r := maps.GeocodingRequest{
 		Address: locName,
 	}
-	resp, err := client.Geocode(context.Background(), &r)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout))
+	defer cancel()
+	resp, err := client.Geocode(ctx, &r)
+	countCall(providerGoogleMaps, err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to geocode location: %w", err)
 	}
@@ -125,95 +301,69 @@ func getLocation(cfg *Config, locName string) (*location, error) {
 	if len(resp) == 0 {
 		return nil, fmt.Errorf("location not found")
 	}
-	return &location{
+	loc := &location{
 		name: resp[0].AddressComponents[0].LongName,
 		lat:  resp[0].Geometry.Location.Lat,
 		lon:  resp[0].Geometry.Location.Lng,
-	}, nil
-}
-
-func getWeather(cfg *Config, loc *location) (*openweathermap.Weather, error) {
-	return openweathermap.Request(
-		cfg.OpenweathermapAPIKey,
-		loc.lat,
-		loc.lon,
-		[]openweathermap.Exclude{
-			openweathermap.Minutely,
-			openweathermap.Hourly,
-			openweathermap.Daily,
-			openweathermap.Alerts,
-		},
-		openweathermap.Units(cfg.Units),
-		openweathermap.Lang(cfg.Language),
-		cfg.Debug,
-	)
-}
-
-type weatherItem struct {
-	menuitem *systray.MenuItem
-	loc      location
+	}
+	geocodeCache.Store(locName, loc)
+	return loc, nil
 }
 
-func updateCurrentLocation(cfg *Config, g *Graph) {
-	tempUnit := openweathermap.TempUnits[openweathermap.Units(cfg.Units)]
-	curLocName, err := getCurrentLocation(cfg)
-	if err != nil {
-		log.Printf("Cannot get current location: %v", err)
-		return
-	}
-	curLoc, err := getLocation(cfg, curLocName)
-	if err != nil {
-		log.Fatalf("Failed to get location '%s': %v", curLocName, err)
-	}
-	curLocWea, err := getWeather(cfg, curLoc)
-	if err != nil {
-		systray.SetTitle("failed to get weather")
-		log.Printf("failed to get weather for '%s': %v", curLoc.name, err)
-		// try the other locations without stopping
-	} else {
-		systray.SetTitle(fmt.Sprintf("%s: %.01f%s %s", curLoc.name, curLocWea.Current.Temp, tempUnit, curLocWea.Current.Weather[0].Description))
-		if cfg.ShowGraph {
-			g.SetNext(int(curLocWea.Current.Temp))
-			icon, err := g.ToIcon()
-			if err != nil {
-				log.Printf("Failed to convert to icon, skipping: %v", err)
-			} else {
-				systray.SetIcon(icon)
-			}
-		} else {
-			systray.SetIcon(icons.Icons[curLocWea.Current.Weather[0].Icon])
-		}
+func getWeather(ctx context.Context, cfg *Config, loc *location) (*openweathermap.Weather, error) {
+	if err := apiUsage.Reserve(cfg, providerOpenWeatherMap); err != nil {
+		return nil, err
 	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout))
+	defer cancel()
+	return withContext(ctx, func() (*openweathermap.Weather, error) {
+		wea, err := openweathermap.Request(
+			cfg.OpenweathermapAPIKey,
+			loc.lat,
+			loc.lon,
+			[]openweathermap.Exclude{
+				openweathermap.Minutely,
+			},
+			openweathermap.Units(cfg.Units),
+			openweathermap.Lang(cfg.Language),
+			cfg.Debug,
+		)
+		countCall(providerOpenWeatherMap, err)
+		return wea, err
+	})
 }
 
-func updateWeather(cfg *Config, items []weatherItem, lastUpdateItem *systray.MenuItem, doCurrentLocation bool, g *Graph) {
-	tempUnit := openweathermap.TempUnits[openweathermap.Units(cfg.Units)]
-
-	if doCurrentLocation {
-		updateCurrentLocation(cfg, g)
+// withContext runs fn in its own goroutine and returns its result, or the
+// context's error if the context is done first. This is used for API clients
+// that do not accept a context, so that a slow request does not hold up the
+// caller. The abandoned call is bounded by http.DefaultClient's timeout.
+func withContext[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	type result struct {
+		v   T
+		err error
 	}
-	for _, item := range items {
-		var text string
-		wea, err := getWeather(cfg, &item.loc)
-		if err != nil {
-			log.Printf("failed to get weather for '%s': %v", item.loc.name, err)
-			text = "failed to update"
-		} else {
-			text = fmt.Sprintf(
-				"%s: %.02f%s %s",
-				item.loc.name,
-				wea.Current.Temp, tempUnit,
-				wea.Current.Weather[0].Description,
-			)
-			item.menuitem.SetIcon(icons.Icons[wea.Current.Weather[0].Icon])
-		}
-		item.menuitem.SetTitle(text)
+	ch := make(chan result, 1)
+	go func() {
+		v, err := fn()
+		ch <- result{v: v, err: err}
+	}()
+	select {
+	case r := <-ch:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
 	}
-	lastUpdateItem.SetTitle(fmt.Sprintf("Last update: %s", time.Now().Format("Mon Jan 2 15:04:05 MST")))
 }
 
-func getCurrentLocation(cfg *Config) (string, error) {
-	resp, err := ipapi.Get(nil, nil)
+func getCurrentLocation(ctx context.Context, cfg *Config) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout))
+	defer cancel()
+	resp, err := withContext(ctx, func() (*ipapi.IPAPI, error) {
+		resp, err := ipapi.Get(nil, nil)
+		countCall(providerIPAPI, err)
+		return resp, err
+	})
 	if err != nil {
 		return "", fmt.Errorf("ipapi.Get failed: %w", err)
 	}
@@ -223,87 +373,6 @@ func getCurrentLocation(cfg *Config) (string, error) {
 	return fmt.Sprintf("%s, %s", resp.City, resp.CountryCode), nil
 }
 
-func onReady(configFile string, cfg *Config, updateSignal <-chan struct{}) {
-	var g *Graph
-	if cfg.ShowGraph {
-		g = NewGraph(100, 100, &darkGreen, &gray, graphStyleBar)
-		g.Blank()
-		icon, err := g.ToIcon()
-		if err != nil {
-			log.Fatalf("Failed to convert to icon: %v", err)
-		}
-		systray.SetIcon(icon)
-	}
-
-	// use the weather icon if the user is not requesting the temperature graph
-	if !cfg.ShowGraph {
-		systray.SetIcon(icons.Icon01d)
-	}
-	systray.SetTitle("Weather")
-	systray.SetTooltip("Weather app")
-
-	mUpdate := systray.AddMenuItem("Update weather now", "Force an update of the weather information for all the locations")
-	var mInterval *systray.MenuItem
-	if cfg.Interval == 0 {
-		mInterval = systray.AddMenuItem("Weather will not update automatically", "No interval is defined in the config file, or zero is set")
-	} else {
-		mInterval = systray.AddMenuItem(fmt.Sprintf("Weather will update every %s", cfg.Interval), "The weather information will automatically update at the configured interval")
-	}
-	mLastUpdate := systray.AddMenuItem("Last updated: never", "Show the last time weather was updated")
-	mLastUpdate.Disable()
-	mInterval.Disable()
-	mEdit := systray.AddMenuItem("Edit config", "Open configuration file for editing")
-	systray.AddSeparator()
-
-	// Sets the icon of a menu item. Only available on Mac and Windows.
-
-	var items []weatherItem
-
-	for _, locName := range cfg.Locations {
-		loc, err := getLocation(cfg, locName)
-		if err != nil {
-			log.Fatalf("Failed to get location '%s': %v", locName, err)
-		}
-		items = append(items, weatherItem{
-			loc: location{
-				name: loc.name,
-				lat:  loc.lat,
-				lon:  loc.lon,
-			},
-			menuitem: systray.AddMenuItem(
-				fmt.Sprintf("%s: not loaded yet", loc.name),
-				fmt.Sprintf("Weather for %s", loc.name),
-			),
-		},
-		)
-	}
-	systray.AddSeparator()
-	mQuit := systray.AddMenuItem("Quit", "Terminate the app")
-	mQuit.SetIcon(Icon)
-
-	updateWeather(cfg, items, mLastUpdate, true, g)
-	go func() {
-		timer := time.NewTicker(time.Duration(cfg.Interval))
-		log.Printf("Updating weather every %s", cfg.Interval)
-		for {
-			select {
-			case <-mQuit.ClickedCh:
-				systray.Quit()
-			case <-mEdit.ClickedCh:
-				if err := editConfigFile(cfg, configFile); err != nil {
-					log.Printf("Failed to edit config file: %v", err)
-				}
-			case <-mUpdate.ClickedCh:
-				updateWeather(cfg, items, mLastUpdate, true, g)
-			case <-timer.C:
-				updateWeather(cfg, items, mLastUpdate, true, g)
-			case <-updateSignal:
-				updateWeather(cfg, items, mLastUpdate, true, g)
-			}
-		}
-	}()
-}
-
 func editConfigFile(cfg *Config, configFile string) error {
 	e := editor.New()
 	if cfg != nil {
@@ -321,7 +390,3 @@ func editConfigFile(cfg *Config, configFile string) error {
 	}
 	return editor.Open(configFile)
 }
-
-func onExit() {
-	// clean up here
-}
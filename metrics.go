@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// counter is a Prometheus counter, optionally partitioned by the value of a
+// single label.
+type counter struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounter(name, help, label string) *counter {
+	return &counter{name: name, help: help, label: label, values: make(map[string]float64)}
+}
+
+// Inc increments the counter for the given label value, which is ignored if
+// the counter has no label.
+func (c *counter) Inc(labelValue string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelValue]++
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if c.label == "" {
+		fmt.Fprintf(w, "%s %g\n", c.name, c.values[""])
+		return
+	}
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %g\n", c.name, c.label, escapeLabel(k), c.values[k])
+	}
+}
+
+// counters exported on /metrics.
+var (
+	apiCallsTotal         = newCounter("wea_api_calls_total", "Number of API calls.", "provider")
+	apiErrorsTotal        = newCounter("wea_api_errors_total", "Number of failed API calls.", "provider")
+	geocodeCacheHitsTotal = newCounter("wea_geocoding_cache_hits_total", "Number of locations found in the geocoding cache.", "")
+	geocodeCacheMissTotal = newCounter("wea_geocoding_cache_misses_total", "Number of locations not found in the geocoding cache.", "")
+)
+
+// countCall records an API call and its outcome.
+func countCall(provider string, err error) {
+	apiCallsTotal.Inc(provider)
+	if err != nil {
+		apiErrorsTotal.Inc(provider)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// gauge describes a per-location gauge computed from a cache entry.
+type gauge struct {
+	name, help string
+	value      func(e *cacheEntry, units string) float64
+}
+
+var locationGauges = []gauge{
+	{"wea_temperature_celsius", "Temperature.", func(e *cacheEntry, units string) float64 {
+		return toCelsius(e.Weather.Current.Temp, units)
+	}},
+	{"wea_feels_like_celsius", "Perceived temperature.", func(e *cacheEntry, units string) float64 {
+		return toCelsius(e.Weather.Current.FeelsLike, units)
+	}},
+	{"wea_humidity_percent", "Relative humidity.", func(e *cacheEntry, units string) float64 {
+		return float64(e.Weather.Current.Humidity)
+	}},
+	{"wea_pressure_hpa", "Atmospheric pressure at sea level.", func(e *cacheEntry, units string) float64 {
+		return float64(e.Weather.Current.Pressure)
+	}},
+	{"wea_wind_speed_meters_per_second", "Wind speed.", func(e *cacheEntry, units string) float64 {
+		return toMetersPerSecond(e.Weather.Current.WindSpeed, units)
+	}},
+	{"wea_wind_direction_degrees", "Wind direction, meteorological.", func(e *cacheEntry, units string) float64 {
+		return float64(e.Weather.Current.WindDeg)
+	}},
+	{"wea_clouds_percent", "Cloudiness.", func(e *cacheEntry, units string) float64 {
+		return float64(e.Weather.Current.Clouds)
+	}},
+	{"wea_last_update_timestamp_seconds", "Time of the last successful update.", func(e *cacheEntry, units string) float64 {
+		return float64(e.Updated.Unix())
+	}},
+}
+
+// newMetricsHandler returns a handler serving the metrics in the Prometheus
+// text format. Weather values are converted to metric units.
+func newMetricsHandler(cfg *Config, cache *weatherCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		var sb strings.Builder
+		for _, c := range []*counter{apiCallsTotal, apiErrorsTotal, geocodeCacheHitsTotal, geocodeCacheMissTotal} {
+			c.write(&sb)
+		}
+		type labelled struct {
+			labels string
+			entry  *cacheEntry
+		}
+		var entries []labelled
+		for _, e := range cache.Locations() {
+			e := e
+			entries = append(entries, labelled{fmt.Sprintf(`location="%s"`, escapeLabel(e.Name)), &e})
+		}
+		if e := cache.Current(); e != nil {
+			entries = append(entries, labelled{fmt.Sprintf(`location="%s",current="true"`, escapeLabel(e.Name)), e})
+		}
+		for _, g := range locationGauges {
+			fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+			for _, l := range entries {
+				if l.entry.Weather == nil || l.entry.Weather.Current == nil {
+					continue
+				}
+				fmt.Fprintf(&sb, "%s{%s} %g\n", g.name, l.labels, g.value(l.entry, cfg.Units))
+			}
+		}
+		if _, err := io.WriteString(w, sb.String()); err != nil {
+			log.Printf("Failed to write metrics: %v", err)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"time"
+)
+
+// moonriseAltitude is the altitude of the center of the moon as seen from the
+// location, in degrees, at moonrise and moonset. It accounts for atmospheric
+// refraction and for the mean apparent radius of the moon. The parallax, which
+// depends on the distance of the moon, is applied by moonAltitude.
+const moonriseAltitude = -0.826
+
+// earthRadius is the equatorial radius of the earth, in kilometres.
+const earthRadius = 6378.14
+
+// obliquity is the obliquity of the ecliptic, in degrees.
+const obliquity = 23.4397
+
+// moonStep is the step used to search for moonrise and moonset.
+const moonStep = 10 * time.Minute
+
+// moonPhases are the names and emoji of the phases of the moon, starting from
+// the new moon.
+var moonPhases = []struct {
+	name, emoji string
+}{
+	{"New moon", "🌑"},
+	{"Waxing crescent", "🌒"},
+	{"First quarter", "🌓"},
+	{"Waxing gibbous", "🌔"},
+	{"Full moon", "🌕"},
+	{"Waning gibbous", "🌖"},
+	{"Last quarter", "🌗"},
+	{"Waning crescent", "🌘"},
+}
+
+// moonInfo is the moon as seen from a location during one day.
+type moonInfo struct {
+	// Phase is the fraction of the lunar cycle, where 0 is the new moon,
+	// 0.25 the first quarter, 0.5 the full moon and 0.75 the last quarter.
+	Phase float64
+	// Illumination is the fraction of the moon's disk that is lit, from 0
+	// to 1.
+	Illumination float64
+	Name         string
+	Emoji        string
+	// Rise and Set are the moonrise and moonset of the day, or zero if the
+	// moon does not rise or set that day.
+	Rise, Set time.Time
+}
+
+func daysSinceJ2000(t time.Time) float64 {
+	return timeToJulian(t) - j2000
+}
+
+// moonCoords returns the ecliptic longitude, the right ascension and the
+// declination of the moon, in degrees, and its distance from the center of the
+// earth, in kilometres, d days after J2000. This is a low precision
+// approximation, good to a fraction of a degree.
+func moonCoords(d float64) (float64, float64, float64, float64) {
+	l := 218.316 + 13.176396*d // mean longitude
+	m := 134.963 + 13.064993*d // mean anomaly
+	f := 93.272 + 13.229350*d  // mean distance
+	lon := l + 6.289*sinDeg(m)
+	lat := 5.128 * sinDeg(f)
+	ra := math.Atan2(sinDeg(lon)*cosDeg(obliquity)-math.Tan(lat*math.Pi/180)*sinDeg(obliquity), cosDeg(lon)) * 180 / math.Pi
+	dec := math.Asin(sinDeg(lat)*cosDeg(obliquity)+cosDeg(lat)*sinDeg(obliquity)*sinDeg(lon)) * 180 / math.Pi
+	dist := 385001 - 20905*cosDeg(m)
+	return math.Mod(lon, 360), ra, dec, dist
+}
+
+// moonAltitude returns the altitude of the moon as seen from the surface of
+// the earth, in degrees, at the given time and location.
+func moonAltitude(t time.Time, lat, lon float64) float64 {
+	d := daysSinceJ2000(t)
+	_, ra, dec, dist := moonCoords(d)
+	siderealTime := 280.16 + 360.9856235*d + lon
+	h := siderealTime - ra
+	alt := math.Asin(sinDeg(lat)*sinDeg(dec)+cosDeg(lat)*cosDeg(dec)*cosDeg(h)) * 180 / math.Pi
+	// the moon is close enough for the observer not being at the center of
+	// the earth to lower it by up to a degree
+	parallax := math.Asin(earthRadius/dist) * 180 / math.Pi
+	return alt - parallax*cosDeg(alt)
+}
+
+// moonPhase returns the phase of the moon at the given time, from the
+// elongation of the moon from the sun, and the fraction of its disk that is
+// lit.
+func moonPhase(t time.Time) (float64, float64) {
+	d := daysSinceJ2000(t)
+	moonLon, _, _, _ := moonCoords(d)
+	_, sunLon := sunAnomalyAndLongitude(d)
+	elongation := math.Mod(moonLon-sunLon+720, 360)
+	return elongation / 360, (1 - cosDeg(elongation)) / 2
+}
+
+// getMoon returns the moon phase at t, and the moonrise and moonset during the
+// day containing t in the given timezone, at the given location.
+func getMoon(t time.Time, tz *time.Location, lat, lon float64) moonInfo {
+	var m moonInfo
+	m.Phase, m.Illumination = moonPhase(t)
+	p := moonPhases[int(math.Round(m.Phase*float64(len(moonPhases))))%len(moonPhases)]
+	m.Name, m.Emoji = p.name, p.emoji
+
+	local := t.In(tz)
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, tz)
+	end := start.AddDate(0, 0, 1)
+	prevT, prevAlt := start, moonAltitude(start, lat, lon)-moonriseAltitude
+	for cur := start.Add(moonStep); !cur.After(end); cur = cur.Add(moonStep) {
+		alt := moonAltitude(cur, lat, lon) - moonriseAltitude
+		if (prevAlt < 0) != (alt < 0) {
+			// interpolate the time of the crossing
+			frac := prevAlt / (prevAlt - alt)
+			crossing := prevT.Add(time.Duration(frac * float64(moonStep))).Round(time.Minute)
+			if alt >= 0 && m.Rise.IsZero() {
+				m.Rise = crossing
+			} else if alt < 0 && m.Set.IsZero() {
+				m.Set = crossing
+			}
+		}
+		prevT, prevAlt = cur, alt
+	}
+	return m
+}
+
+// moonOverlay draws the moon in the given phase in the bottom right corner of
+// an icon, which must be a PNG or JPEG image, and returns it as a PNG image.
+func moonOverlay(icon []byte, phase float64) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(icon))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode icon: %w", err)
+	}
+	b := src.Bounds()
+	img := image.NewRGBA(b)
+	draw.Draw(img, b, src, b.Min, draw.Src)
+
+	lit := color.RGBA{250, 240, 200, 255}
+	dark := color.RGBA{60, 60, 70, 255}
+	r := float64(b.Dx()) / 5
+	cx, cy := float64(b.Max.X)-r-1, float64(b.Max.Y)-r-1
+	// the terminator is the ellipse with this horizontal semi-axis
+	terminator := cosDeg(phase * 360)
+	for y := int(cy - r); y <= int(cy+r); y++ {
+		for x := int(cx - r); x <= int(cx+r); x++ {
+			u, v := (float64(x)+0.5-cx)/r, (float64(y)+0.5-cy)/r
+			if u*u+v*v > 1 {
+				continue
+			}
+			xt := terminator * math.Sqrt(1-v*v)
+			col := dark
+			// waxing moons are lit on the right, waning ones on the left,
+			// as seen from the northern hemisphere
+			if (phase < 0.5 && u > xt) || (phase >= 0.5 && u < -xt) {
+				col = lit
+			}
+			img.Set(x, y, col)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// notify shows a desktop notification, using the freedesktop.org
+// notification service on the session bus.
+func notify(summary, body string) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the session bus: %w", err)
+	}
+	obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	call := obj.Call("org.freedesktop.Notifications.Notify", 0,
+		progname,  // app_name
+		uint32(0), // replaces_id
+		"",        // app_icon
+		summary,
+		body,
+		[]string{},                // actions
+		map[string]dbus.Variant{}, // hints
+		int32(-1),                 // expire_timeout, server default
+	)
+	if call.Err != nil {
+		return fmt.Errorf("failed to send notification: %w", call.Err)
+	}
+	return nil
+}
+
+// gnomeDoNotDisturb returns true if GNOME's do not disturb mode is on, i.e.
+// notification banners are disabled.
+func gnomeDoNotDisturb() (bool, error) {
+	out, err := exec.Command("gsettings", "get", "org.gnome.desktop.notifications", "show-banners").Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to read GNOME notification settings: %w", err)
+	}
+	return strings.TrimSpace(string(out)) == "false", nil
+}
+
+// notification is a notification waiting to be sent.
+type notification struct {
+	summary, body string
+	// sent, if not nil, is called once the notification has been sent.
+	sent func()
+}
+
+// notifier queues notifications and sends them in a batch, unless the user
+// does not want to be disturbed.
+type notifier struct {
+	cfg     *Config
+	mu      sync.Mutex
+	pending []notification
+}
+
+func newNotifier(cfg *Config) *notifier {
+	return &notifier{cfg: cfg}
+}
+
+// Queue adds a notification to the next batch. If sent is not nil, it is
+// called once the notification has been sent, so that alerts that were held
+// back are queued again at the next update if they are still relevant.
+func (n *notifier) Queue(summary, body string, sent func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pending = append(n.pending, notification{summary: summary, body: body, sent: sent})
+}
+
+// quiet returns the reason not to send notifications now, or an empty string.
+func (n *notifier) quiet(now time.Time) string {
+	if n.cfg.quietHours.Quiet(now) {
+		return "quiet hours"
+	}
+	if n.cfg.RespectDND {
+		dnd, err := gnomeDoNotDisturb()
+		if err != nil {
+			log.Printf("Cannot check do not disturb mode: %v", err)
+		} else if dnd {
+			return "do not disturb"
+		}
+	}
+	return ""
+}
+
+// Flush sends the queued notifications: a single one as is, several ones as one
+// summary notification. During quiet hours or in do not disturb mode, or if
+// sending fails, they are dropped without being marked as sent.
+func (n *notifier) Flush() {
+	n.mu.Lock()
+	pending := n.pending
+	n.pending = nil
+	n.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	if reason := n.quiet(time.Now()); reason != "" {
+		log.Printf("Not sending %d notification(s) because of %s", len(pending), reason)
+		return
+	}
+	summary, body := pending[0].summary, pending[0].body
+	if len(pending) > 1 {
+		summary = fmt.Sprintf("%d weather alerts", len(pending))
+		lines := make([]string, 0, len(pending))
+		for _, p := range pending {
+			lines = append(lines, p.summary+": "+p.body)
+		}
+		body = strings.Join(lines, "\n")
+	}
+	if err := notify(summary, body); err != nil {
+		log.Printf("Failed to send notification: %v", err)
+		return
+	}
+	for _, p := range pending {
+		if p.sent != nil {
+			p.sent()
+		}
+	}
+}
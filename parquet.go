@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// This is a minimal Parquet writer, enough to export the history as a flat
+// table of optional columns, without compression. See
+// https://github.com/apache/parquet-format for the format, and
+// https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md
+// for the encoding of its metadata.
+
+// parquetMagic starts and ends every Parquet file.
+const parquetMagic = "PAR1"
+
+// parquetType is the type of a column. Each one maps to a physical type and,
+// optionally, a converted type that tells readers how to interpret it.
+type parquetType int
+
+const (
+	parquetString parquetType = iota
+	parquetInt64
+	parquetDouble
+	parquetTimestamp
+)
+
+// Parquet physical types, converted types, encodings and repetition types, as
+// defined in parquet.thrift.
+const (
+	physicalInt64     = 2
+	physicalDouble    = 5
+	physicalByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	repetitionOptional = 1
+)
+
+// physical returns the physical type of the column, and its converted type or
+// -1.
+func (t parquetType) physical() (int32, int32) {
+	switch t {
+	case parquetString:
+		return physicalByteArray, convertedUTF8
+	case parquetInt64:
+		return physicalInt64, -1
+	case parquetDouble:
+		return physicalDouble, -1
+	case parquetTimestamp:
+		return physicalInt64, convertedTimestampMillis
+	}
+	panic(fmt.Sprintf("unknown parquet type %d", t))
+}
+
+// parquetColumn is a column of a Parquet file.
+type parquetColumn struct {
+	name string
+	typ  parquetType
+}
+
+// parquetRowGroupRows is the number of rows buffered in memory before they
+// are written out as a row group.
+const parquetRowGroupRows = 64 * 1024
+
+// parquetWriter buffers rows in memory, and writes them as a row group of a
+// Parquet file every parquetRowGroupRows rows. Close writes the rest and the
+// file metadata.
+type parquetWriter struct {
+	w       io.Writer
+	columns []parquetColumn
+	// groupRows is the number of rows per row group.
+	groupRows int
+	// defined holds, for each column, whether each row of the current row
+	// group has a value.
+	defined [][]bool
+	// values holds, for each column, the PLAIN encoding of its values in
+	// the current row group.
+	values []bytes.Buffer
+	rows   int
+	// offset is the number of bytes written so far, and groups the row
+	// groups they contain.
+	offset int64
+	groups []parquetRowGroup
+	err    error
+}
+
+// parquetRowGroup is a row group that has been written, with the offset and
+// size of the chunk of each column.
+type parquetRowGroup struct {
+	rows    int
+	offsets []int64
+	sizes   []int64
+}
+
+// newParquetWriter starts a Parquet file with the given columns. Errors
+// writing to w are returned by the following calls to Write or Close.
+func newParquetWriter(w io.Writer, columns []parquetColumn) *parquetWriter {
+	p := &parquetWriter{
+		w:         w,
+		columns:   columns,
+		groupRows: parquetRowGroupRows,
+		defined:   make([][]bool, len(columns)),
+		values:    make([]bytes.Buffer, len(columns)),
+	}
+	p.write([]byte(parquetMagic))
+	return p
+}
+
+// Write adds a row, with one value per column. A nil value is a null,
+// otherwise it must be a string, int64, float64 or time.Time according to the
+// type of the column.
+func (p *parquetWriter) Write(row []interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	if len(row) != len(p.columns) {
+		return fmt.Errorf("row has %d values, expected %d", len(row), len(p.columns))
+	}
+	for i, v := range row {
+		if v == nil {
+			p.defined[i] = append(p.defined[i], false)
+			continue
+		}
+		buf := &p.values[i]
+		switch p.columns[i].typ {
+		case parquetString:
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("column '%s': expected string, got %T", p.columns[i].name, v)
+			}
+			_ = binary.Write(buf, binary.LittleEndian, uint32(len(s)))
+			buf.WriteString(s)
+		case parquetInt64:
+			n, ok := v.(int64)
+			if !ok {
+				return fmt.Errorf("column '%s': expected int64, got %T", p.columns[i].name, v)
+			}
+			_ = binary.Write(buf, binary.LittleEndian, n)
+		case parquetDouble:
+			f, ok := v.(float64)
+			if !ok {
+				return fmt.Errorf("column '%s': expected float64, got %T", p.columns[i].name, v)
+			}
+			_ = binary.Write(buf, binary.LittleEndian, math.Float64bits(f))
+		case parquetTimestamp:
+			t, ok := v.(time.Time)
+			if !ok {
+				return fmt.Errorf("column '%s': expected time.Time, got %T", p.columns[i].name, v)
+			}
+			_ = binary.Write(buf, binary.LittleEndian, t.UnixMilli())
+		}
+		p.defined[i] = append(p.defined[i], true)
+	}
+	p.rows++
+	if p.rows == p.groupRows {
+		p.flush()
+	}
+	return p.err
+}
+
+// definitionLevels returns the RLE encoding of the definition levels of a
+// column, prefixed by its length: one run per sequence of nulls or values.
+func definitionLevels(defined []bool) []byte {
+	var runs []byte
+	for start := 0; start < len(defined); {
+		end := start
+		for end < len(defined) && defined[end] == defined[start] {
+			end++
+		}
+		runs = appendUvarint(runs, uint64(end-start)<<1)
+		if defined[start] {
+			runs = append(runs, 1)
+		} else {
+			runs = append(runs, 0)
+		}
+		start = end
+	}
+	levels := make([]byte, 4, 4+len(runs))
+	binary.LittleEndian.PutUint32(levels, uint32(len(runs)))
+	return append(levels, runs...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// write writes b to the file, and keeps the first error.
+func (p *parquetWriter) write(b []byte) {
+	if p.err != nil {
+		return
+	}
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	p.err = err
+}
+
+// flush writes the buffered rows as a row group, with every column as a single
+// data page.
+func (p *parquetWriter) flush() {
+	if p.rows == 0 {
+		return
+	}
+	g := parquetRowGroup{
+		rows:    p.rows,
+		offsets: make([]int64, len(p.columns)),
+		sizes:   make([]int64, len(p.columns)),
+	}
+	for i := range p.columns {
+		page := append(definitionLevels(p.defined[i]), p.values[i].Bytes()...)
+		var h thriftWriter
+		h.i32(1, 0) // type: DATA_PAGE
+		h.i32(2, int32(len(page)))
+		h.i32(3, int32(len(page)))
+		h.beginStruct(5) // data_page_header
+		h.i32(1, int32(p.rows))
+		h.i32(2, encodingPlain)
+		h.i32(3, encodingRLE) // definition levels
+		h.i32(4, encodingRLE) // repetition levels, none in a flat schema
+		h.endStruct()
+		h.stop()
+		g.offsets[i] = p.offset
+		p.write(h.Bytes())
+		p.write(page)
+		g.sizes[i] = p.offset - g.offsets[i]
+
+		p.defined[i] = p.defined[i][:0]
+		p.values[i].Reset()
+	}
+	p.groups = append(p.groups, g)
+	p.rows = 0
+}
+
+// Close writes the buffered rows and the file metadata.
+func (p *parquetWriter) Close() error {
+	p.flush()
+	var rows int64
+	for _, g := range p.groups {
+		rows += int64(g.rows)
+	}
+
+	var m thriftWriter
+	m.i32(1, 1) // version
+	m.beginList(2, thriftStruct, len(p.columns)+1)
+	m.beginElement() // the root of the schema
+	m.bin(4, "schema")
+	m.i32(5, int32(len(p.columns)))
+	m.endStruct()
+	for _, c := range p.columns {
+		physical, converted := c.typ.physical()
+		m.beginElement()
+		m.i32(1, physical)
+		m.i32(3, repetitionOptional)
+		m.bin(4, c.name)
+		if converted >= 0 {
+			m.i32(6, converted)
+		}
+		m.endStruct()
+	}
+	m.i64(3, rows)
+	m.beginList(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		m.beginElement()
+		m.beginList(1, thriftStruct, len(p.columns))
+		var total int64
+		for i, c := range p.columns {
+			physical, _ := c.typ.physical()
+			m.beginElement()
+			m.i64(2, g.offsets[i])
+			m.beginStruct(3) // meta_data
+			m.i32(1, physical)
+			m.beginList(2, thriftI32, 2)
+			m.zigzag(encodingPlain)
+			m.zigzag(encodingRLE)
+			m.beginList(3, thriftBinary, 1)
+			m.str(c.name)
+			m.i32(4, 0) // codec: UNCOMPRESSED
+			m.i64(5, int64(g.rows))
+			m.i64(6, g.sizes[i])
+			m.i64(7, g.sizes[i])
+			m.i64(9, g.offsets[i])
+			m.endStruct()
+			m.endStruct()
+			total += g.sizes[i]
+		}
+		m.i64(2, total)
+		m.i64(3, int64(g.rows))
+		m.endStruct()
+	}
+	m.bin(6, progname)
+	m.stop()
+
+	var footer [4]byte
+	binary.LittleEndian.PutUint32(footer[:], uint32(m.Len()))
+	p.write(m.Bytes())
+	p.write(footer[:])
+	p.write([]byte(parquetMagic))
+	return p.err
+}
+
+// Thrift compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol. Fields must
+// be written in increasing order of their ID within each struct.
+type thriftWriter struct {
+	bytes.Buffer
+	// lastID is the ID of the previous field in the current struct, and
+	// stack holds the ones of the enclosing structs.
+	lastID int16
+	stack  []int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.zigzag(int64(id))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.Write(appendUvarint(nil, v))
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	t.Write(buf[:binary.PutVarint(buf[:], v)])
+}
+
+func (t *thriftWriter) str(s string) {
+	t.varint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) bin(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.str(s)
+}
+
+// beginList starts a list field, whose n elements must follow.
+func (t *thriftWriter) beginList(id int16, elemType byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | elemType)
+		return
+	}
+	t.WriteByte(0xf0 | elemType)
+	t.varint(uint64(n))
+}
+
+// beginStruct starts a struct field, which must be ended with endStruct.
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElement()
+}
+
+// beginElement starts a struct that is an element of a list, which must be
+// ended with endStruct.
+func (t *thriftWriter) beginElement() {
+	t.stack = append(t.stack, t.lastID)
+	t.lastID = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.lastID = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+// stop ends the outermost struct.
+func (t *thriftWriter) stop() {
+	t.WriteByte(0)
+}
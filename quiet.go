@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeWindow is a daily time window, like "18:00-08:00", which can span
+// midnight.
+type timeWindow struct {
+	// from and to are in minutes since midnight.
+	from, to int
+}
+
+// parseClock parses a time of the day like "18:00" into minutes since
+// midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s', must be like 18:00", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseWindow parses a time window like "18:00-08:00".
+func parseWindow(s string) (*timeWindow, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid time window '%s', must be like 18:00-08:00", s)
+	}
+	var (
+		w   timeWindow
+		err error
+	)
+	if w.from, err = parseClock(parts[0]); err != nil {
+		return nil, err
+	}
+	if w.to, err = parseClock(parts[1]); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func minuteOfDay(t time.Time) int {
+	return t.Hour()*60 + t.Minute()
+}
+
+// spansMidnight returns true if the window ends on the day after it starts.
+func (w *timeWindow) spansMidnight() bool {
+	return w.from > w.to
+}
+
+// Contains returns true if t is within the window.
+func (w *timeWindow) Contains(t time.Time) bool {
+	m := minuteOfDay(t)
+	if w.spansMidnight() {
+		return m >= w.from || m < w.to
+	}
+	return m >= w.from && m < w.to
+}
+
+// weekdays maps the keys of quiet_hours to days of the week.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// quietHours are the time windows, per day of the week, during which no
+// notification is sent. A window that spans midnight belongs to the day it
+// starts on.
+type quietHours [7]*timeWindow
+
+// parseQuietHours parses the quiet_hours configuration, which maps days of the
+// week like "mon", or "default" for the days that are not listed, to time
+// windows.
+func parseQuietHours(cfg map[string]string) (quietHours, error) {
+	var q quietHours
+	if def, ok := cfg["default"]; ok {
+		w, err := parseWindow(def)
+		if err != nil {
+			return q, fmt.Errorf("default: %w", err)
+		}
+		for i := range q {
+			q[i] = w
+		}
+	}
+	for day, s := range cfg {
+		if day == "default" {
+			continue
+		}
+		wd, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return q, fmt.Errorf("unknown day '%s', must be one of sun, mon, tue, wed, thu, fri, sat or default", day)
+		}
+		w, err := parseWindow(s)
+		if err != nil {
+			return q, fmt.Errorf("%s: %w", day, err)
+		}
+		q[wd] = w
+	}
+	return q, nil
+}
+
+// Quiet returns true if t is within the quiet hours of its day, or of the day
+// before if they span midnight.
+func (q quietHours) Quiet(t time.Time) bool {
+	m := minuteOfDay(t)
+	if w := q[t.Weekday()]; w != nil {
+		if w.spansMidnight() && m >= w.from || !w.spansMidnight() && w.Contains(t) {
+			return true
+		}
+	}
+	if w := q[(t.Weekday()+6)%7]; w != nil && w.spansMidnight() && m < w.to {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fridayAt returns the given local time on Friday 2024-03-01, or on one of
+// the following days.
+func fridayAt(day int, clock string) time.Time {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		panic(err)
+	}
+	return time.Date(2024, 3, 1+day, t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	for _, tc := range []struct {
+		window, clock string
+		want          bool
+	}{
+		{"09:00-17:00", "08:59", false},
+		{"09:00-17:00", "09:00", true},
+		{"09:00-17:00", "16:59", true},
+		{"09:00-17:00", "17:00", false},
+		{"22:00-07:00", "21:59", false},
+		{"22:00-07:00", "22:00", true},
+		{"22:00-07:00", "23:59", true},
+		{"22:00-07:00", "00:00", true},
+		{"22:00-07:00", "06:59", true},
+		{"22:00-07:00", "07:00", false},
+		{"22:00-07:00", "12:00", false},
+		{"12:00-12:00", "12:00", false},
+	} {
+		w, err := parseWindow(tc.window)
+		if err != nil {
+			t.Fatalf("parseWindow(%q): %v", tc.window, err)
+		}
+		if got := w.Contains(fridayAt(0, tc.clock)); got != tc.want {
+			t.Errorf("%s contains %s = %v, want %v", tc.window, tc.clock, got, tc.want)
+		}
+	}
+}
+
+func TestParseWindowErrors(t *testing.T) {
+	for _, s := range []string{"", "22:00", "22:00-", "25:00-07:00", "22:00-07:00-08:00", "10pm-7am"} {
+		if _, err := parseWindow(s); err == nil {
+			t.Errorf("parseWindow(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	q, err := parseQuietHours(map[string]string{
+		"default": "22:00-07:00",
+		"fri":     "23:00-09:00",
+		"sat":     "13:00-15:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		day   int
+		clock string
+		want  bool
+	}{
+		// Thursday's default window spans midnight into Friday
+		{"thursday night into friday", 0, "06:59", true},
+		{"friday morning", 0, "07:00", false},
+		{"friday before its window", 0, "22:30", false},
+		{"friday window", 0, "23:00", true},
+		// Friday's window spans into Saturday, whose own window does not
+		// start before 13:00
+		{"friday night into saturday", 1, "08:59", true},
+		{"saturday morning", 1, "09:00", false},
+		{"saturday window", 1, "14:00", true},
+		{"saturday window end", 1, "15:00", false},
+		// Saturday's window does not span midnight, so Sunday starts
+		// with no quiet hours
+		{"saturday night", 1, "23:00", false},
+		{"sunday early", 2, "01:00", false},
+		{"sunday default", 2, "22:00", true},
+		{"sunday night into monday", 3, "06:00", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := q.Quiet(fridayAt(tc.day, tc.clock)); got != tc.want {
+				t.Errorf("Quiet(%s) = %v, want %v", fridayAt(tc.day, tc.clock).Format("Mon 15:04"), got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseQuietHoursErrors(t *testing.T) {
+	for _, cfg := range []map[string]string{
+		{"monday": "22:00-07:00"},
+		{"default": "22:00"},
+		{"sun": "late"},
+	} {
+		if _, err := parseQuietHours(cfg); err == nil {
+			t.Errorf("parseQuietHours(%v) succeeded, want error", cfg)
+		}
+	}
+}
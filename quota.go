@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirsle/configdir"
+)
+
+// API providers whose calls are accounted for.
+const (
+	providerOpenWeatherMap = "openweathermap"
+	providerGoogleMaps     = "googlemaps"
+	// ip-api.com, used to find the current location. It is only counted in
+	// the metrics, and is not subject to a budget.
+	providerIPAPI = "ipapi"
+)
+
+// usageDayFormat is the format of the day the API usage refers to.
+const usageDayFormat = "2006-01-02"
+
+// apiUsage tracks the API calls made today. It is nil if the usage file could
+// not be loaded, in which case calls are not accounted for.
+var apiUsage *usage
+
+// usage counts the API calls per provider made during a given day, and is
+// saved to disk after every call so that restarting the program does not
+// reset the counters. The file is shared with the other wea processes, like
+// one-shot commands, and is always re-read before being updated.
+type usage struct {
+	mu    sync.Mutex
+	file  string
+	Day   string         `json:"day"`
+	Calls map[string]int `json:"calls"`
+}
+
+// loadUsage loads the API usage from the usage file in the config directory.
+// A missing file is not an error.
+func loadUsage() (*usage, error) {
+	configPath := configdir.LocalConfig(progname)
+	if err := configdir.MakePath(configPath); err != nil {
+		return nil, err
+	}
+	u := usage{
+		file:  path.Join(configPath, "usage.json"),
+		Calls: make(map[string]int),
+	}
+	u.sync(time.Now())
+	return &u, nil
+}
+
+// sync merges the usage file into the counters, keeping the highest count of
+// each provider of the latest day, and resets them if the day has changed.
+// An invalid file is ignored rather than disabling the budgets. Must be called
+// with the lock held.
+func (u *usage) sync(now time.Time) {
+	data, err := os.ReadFile(u.file)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to read API usage: %v", err)
+	}
+	if err == nil {
+		var saved usage
+		if err := json.Unmarshal(data, &saved); err != nil {
+			log.Printf("Ignoring invalid API usage file: %v", err)
+		} else if saved.Day > u.Day {
+			u.Day, u.Calls = saved.Day, saved.Calls
+		} else if saved.Day == u.Day {
+			for provider, calls := range saved.Calls {
+				if calls > u.Calls[provider] {
+					u.Calls[provider] = calls
+				}
+			}
+		}
+	}
+	if day := now.Format(usageDayFormat); u.Day != day || u.Calls == nil {
+		u.Day = day
+		u.Calls = make(map[string]int)
+	}
+}
+
+// save writes the counters to a temporary file, and renames it over the usage
+// file so that readers never see a partial file. Must be called with the lock
+// held, and the file lock.
+func (u *usage) save() error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp := u.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, u.file)
+}
+
+// Reserve records an API call to the given provider, or returns an error if
+// the daily budget for it has been used up. Checking and recording happen
+// under a lock on the usage file, so that neither concurrent updates nor other
+// processes can exceed the budget.
+func (u *usage) Reserve(cfg *Config, provider string) error {
+	if u == nil {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	lock, err := waitLockFile(u.file + ".lock")
+	if err != nil {
+		log.Printf("Failed to lock API usage file, other processes may miscount: %v", err)
+	} else {
+		defer lock.Close()
+	}
+	u.sync(time.Now())
+	if budget := cfg.DailyBudget[provider]; budget != 0 && u.Calls[provider] >= budget {
+		return fmt.Errorf("daily budget of %d %s API calls used up", budget, provider)
+	}
+	u.Calls[provider]++
+	if err := u.save(); err != nil {
+		log.Printf("Failed to save API usage: %v", err)
+	}
+	return nil
+}
+
+// Count returns the number of calls made today to the given provider,
+// including those made by other processes.
+func (u *usage) Count(provider string) int {
+	if u == nil {
+		return 0
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.sync(time.Now())
+	return u.Calls[provider]
+}
+
+// Interval returns the interval between updates that keeps the API calls
+// within the daily budgets, assuming that each update makes callsPerUpdate
+// calls to each provider. It is never shorter than the configured interval,
+// and if a budget is used up it is the time left until the budget resets.
+func (u *usage) Interval(cfg *Config, callsPerUpdate map[string]int) time.Duration {
+	interval := time.Duration(cfg.Interval)
+	now := time.Now()
+	y, m, d := now.Date()
+	untilReset := time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()).Sub(now)
+	for provider, budget := range cfg.DailyBudget {
+		calls := callsPerUpdate[provider]
+		if budget == 0 || calls == 0 {
+			continue
+		}
+		updatesLeft := (budget - u.Count(provider)) / calls
+		if updatesLeft <= 0 {
+			return untilReset
+		}
+		if minInterval := untilReset / time.Duration(updatesLeft); minInterval > interval {
+			interval = minInterval
+		}
+	}
+	return interval
+}
+
+// Summary returns a summary of today's usage and remaining budget, suitable
+// for a menu item.
+func (u *usage) Summary(cfg *Config) string {
+	providers := []string{providerGoogleMaps, providerOpenWeatherMap}
+	parts := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		count := u.Count(provider)
+		if budget := cfg.DailyBudget[provider]; budget != 0 {
+			left := budget - count
+			if left < 0 {
+				left = 0
+			}
+			parts = append(parts, fmt.Sprintf("%s %d/%d (%d left)", provider, count, budget, left))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s %d", provider, count))
+		}
+	}
+	return "API calls today: " + strings.Join(parts, ", ")
+}
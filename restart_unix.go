@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// restart replaces the process with a new one running exe with the same
+// arguments. The PID does not change, so service managers like systemd keep
+// tracking it. It only returns on error.
+func restart(exe string) error {
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
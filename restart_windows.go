@@ -0,0 +1,19 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+)
+
+// restart starts a new process running exe with the same arguments. Windows
+// cannot replace a running process, so the caller must quit afterwards.
+func restart(exe string) error {
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("Started new instance with PID %d", cmd.Process.Pid)
+	return nil
+}
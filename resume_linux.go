@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// watchResume calls fn every time the system resumes from suspend, as
+// reported by logind's PrepareForSleep signal on the system bus. It returns
+// once the subscription is set up, and stops watching when ctx is done.
+func watchResume(ctx context.Context, fn func()) error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the system bus: %w", err)
+	}
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchObjectPath("/org/freedesktop/login1"),
+		dbus.WithMatchInterface("org.freedesktop.login1.Manager"),
+		dbus.WithMatchMember("PrepareForSleep"),
+	); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to subscribe to PrepareForSleep: %w", err)
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+	go func() {
+		defer conn.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig, ok := <-signals:
+				if !ok {
+					return
+				}
+				// the argument is true before suspending, and false after
+				// resuming.
+				if len(sig.Body) != 1 {
+					continue
+				}
+				if start, ok := sig.Body[0].(bool); ok && !start {
+					fn()
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// watchResume is only supported on Linux. On other systems the scheduler
+// still notices a resume from the jump of the wall clock.
+func watchResume(ctx context.Context, fn func()) error {
+	return errors.New("resume notifications are not supported on this OS")
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/openweathermap"
+	"github.com/insomniacslk/xjson"
+)
+
+// Rule is a user-defined condition on the weather of a location, that sends a
+// desktop notification when it becomes true.
+type Rule struct {
+	// Name identifies the rule in the notification.
+	Name string `json:"name"`
+	// Location is one of the configured locations, or empty for the current
+	// location.
+	Location string `json:"location"`
+	// Metric is the value to check, one of ruleMetrics.
+	Metric string `json:"metric"`
+	// Op is either "<" or ">".
+	Op    string  `json:"op"`
+	Value float64 `json:"value"`
+	// Within, if set, checks the hourly forecast up to this far ahead, in
+	// addition to the current weather.
+	Within xjson.Duration `json:"within"`
+	// Between, if set, is the local time window in which the rule can fire,
+	// like "18:00-08:00".
+	Between string `json:"between"`
+	// Hysteresis is how far the metric has to go back past Value before the
+	// rule can fire again.
+	Hysteresis float64 `json:"hysteresis"`
+	// Message, if set, replaces the default notification text.
+	Message string `json:"message"`
+
+	// between is the parsed Between, or nil.
+	between *timeWindow
+	// index is the index of Location in the configured locations, or -1
+	// for the current location.
+	index int
+}
+
+// ruleMetrics extract the metrics that rules can check from a point of the
+// weather, in the configured units. Probabilities are in percent.
+var ruleMetrics = map[string]func(p *openweathermap.PointWeatherSummary, units string) float64{
+	"temp":       func(p *openweathermap.PointWeatherSummary, _ string) float64 { return p.Temp },
+	"feels_like": func(p *openweathermap.PointWeatherSummary, _ string) float64 { return p.FeelsLike },
+	"humidity":   func(p *openweathermap.PointWeatherSummary, _ string) float64 { return float64(p.Humidity) },
+	"pressure":   func(p *openweathermap.PointWeatherSummary, _ string) float64 { return float64(p.Pressure) },
+	"clouds":     func(p *openweathermap.PointWeatherSummary, _ string) float64 { return float64(p.Clouds) },
+	"uvi":        func(p *openweathermap.PointWeatherSummary, _ string) float64 { return p.UVI },
+	"wind_speed": func(p *openweathermap.PointWeatherSummary, _ string) float64 { return p.WindSpeed },
+	"wind_gust": func(p *openweathermap.PointWeatherSummary, _ string) float64 {
+		if p.WindGust == nil {
+			return p.WindSpeed
+		}
+		return *p.WindGust
+	},
+	"pop": func(p *openweathermap.PointWeatherSummary, _ string) float64 { return p.Pop * 100 },
+	"heat_index": func(p *openweathermap.PointWeatherSummary, units string) float64 {
+		return getComfort(p, units).HeatIndex
+	},
+	"wind_chill": func(p *openweathermap.PointWeatherSummary, units string) float64 {
+		return getComfort(p, units).WindChill
+	},
+	"humidex": func(p *openweathermap.PointWeatherSummary, units string) float64 {
+		return getComfort(p, units).Humidex
+	},
+	"apparent_temperature": func(p *openweathermap.PointWeatherSummary, units string) float64 {
+		return getComfort(p, units).ApparentTemperature
+	},
+	"dew_point": func(p *openweathermap.PointWeatherSummary, units string) float64 {
+		return getComfort(p, units).DewPoint
+	},
+}
+
+// check validates the rule, and parses its time window.
+func (r *Rule) check(cfg *Config) error {
+	if r.Name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+	// match the location as configured, since the cache holds the names
+	// returned by geocoding
+	r.index = -1
+	if r.Location != "" {
+		for i, l := range cfg.Locations {
+			if strings.EqualFold(l, r.Location) {
+				r.index = i
+				break
+			}
+		}
+		if r.index == -1 {
+			return fmt.Errorf("location '%s' is not one of the configured locations", r.Location)
+		}
+	}
+	if _, ok := ruleMetrics[r.Metric]; !ok {
+		names := make([]string, 0, len(ruleMetrics))
+		for name := range ruleMetrics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown metric '%s', must be one of %s", r.Metric, strings.Join(names, ", "))
+	}
+	if r.Op != "<" && r.Op != ">" {
+		return fmt.Errorf("op must be either < or >")
+	}
+	if r.Within < 0 {
+		return fmt.Errorf("within cannot be negative")
+	}
+	if r.Hysteresis < 0 {
+		return fmt.Errorf("hysteresis cannot be negative")
+	}
+	if r.Between != "" {
+		var err error
+		if r.between, err = parseWindow(r.Between); err != nil {
+			return fmt.Errorf("between: %w", err)
+		}
+	}
+	return nil
+}
+
+// value returns the value of the rule's metric in the weather: the current one,
+// or the most extreme one in the direction of Op up to Within ahead.
+func (r *Rule) value(w *openweathermap.Weather, units string, now time.Time) float64 {
+	metric := ruleMetrics[r.Metric]
+	v := metric(w.Current, units)
+	if r.Within == 0 {
+		return v
+	}
+	until := now.Add(time.Duration(r.Within))
+	for i := range w.Hourly {
+		h := &w.Hourly[i]
+		if time.Unix(h.Dt, 0).After(until) {
+			break
+		}
+		if r.Op == "<" {
+			v = math.Min(v, metric(h, units))
+		} else {
+			v = math.Max(v, metric(h, units))
+		}
+	}
+	return v
+}
+
+// matches returns true if v satisfies the rule.
+func (r *Rule) matches(v float64) bool {
+	if r.Op == "<" {
+		return v < r.Value
+	}
+	return v > r.Value
+}
+
+// cleared returns true if v is back past the threshold by at least
+// Hysteresis.
+func (r *Rule) cleared(v float64) bool {
+	if r.Op == "<" {
+		return v >= r.Value+r.Hysteresis
+	}
+	return v <= r.Value-r.Hysteresis
+}
+
+// describe returns the default notification text, given the value that made
+// the rule fire.
+func (r *Rule) describe(locName string, v float64) string {
+	cmp := "above"
+	if r.Op == "<" {
+		cmp = "below"
+	}
+	if r.Within != 0 {
+		return fmt.Sprintf("%s: %s reaches %.01f within %s, %s %g", locName, r.Metric, v, time.Duration(r.Within), cmp, r.Value)
+	}
+	return fmt.Sprintf("%s: %s is %.01f, %s %g", locName, r.Metric, v, cmp, r.Value)
+}
+
+// rulesEngine evaluates the rules after each update, and notifies once per
+// episode, i.e. until the condition is cleared again.
+type rulesEngine struct {
+	cfg      *Config
+	cache    *weatherCache
+	notifier *notifier
+	// firing holds, for each rule, whether its notification was sent and
+	// it has not cleared since.
+	firing []bool
+}
+
+func newRulesEngine(cfg *Config, cache *weatherCache, n *notifier) *rulesEngine {
+	return &rulesEngine{
+		cfg:      cfg,
+		cache:    cache,
+		notifier: n,
+		firing:   make([]bool, len(cfg.Rules)),
+	}
+}
+
+// Evaluate checks all the rules against the cache, and queues notifications for
+// those that start matching.
+func (e *rulesEngine) Evaluate() {
+	now := time.Now()
+	cur, locs := e.cache.Current(), e.cache.Locations()
+	for i := range e.cfg.Rules {
+		r := &e.cfg.Rules[i]
+		entry := cur
+		if r.index >= 0 {
+			entry = &locs[r.index]
+		}
+		if entry == nil || entry.Weather == nil || entry.Weather.Current == nil {
+			continue
+		}
+		v := r.value(entry.Weather, e.cfg.Units, now)
+		switch {
+		case e.firing[i]:
+			if r.cleared(v) {
+				log.Printf("Rule '%s' cleared, %s is %.01f", r.Name, r.Metric, v)
+				e.firing[i] = false
+			}
+		case r.matches(v) && (r.between == nil || r.between.Contains(now)):
+			msg := r.Message
+			if msg == "" {
+				msg = r.describe(entry.Name, v)
+			}
+			log.Printf("Rule '%s' fired: %s", r.Name, msg)
+			i := i
+			e.notifier.Queue(r.Name, msg, func() { e.firing[i] = true })
+		}
+	}
+}
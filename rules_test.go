@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/openweathermap"
+	"github.com/insomniacslk/xjson"
+)
+
+// hourlyTemps returns weather with the given current temperature, followed by
+// one hourly forecast per temperature, starting an hour after now.
+func hourlyTemps(now time.Time, current float64, hourly ...float64) *openweathermap.Weather {
+	w := openweathermap.Weather{Current: &openweathermap.PointWeatherSummary{Temp: current}}
+	for i, temp := range hourly {
+		h := openweathermap.PointWeatherSummary{Temp: temp}
+		h.Dt = now.Add(time.Duration(i+1) * time.Hour).Unix()
+		w.Hourly = append(w.Hourly, h)
+	}
+	return &w
+}
+
+func TestRuleValue(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	w := hourlyTemps(now, 10, 8, 12, 3, 15)
+	for _, tc := range []struct {
+		name   string
+		op     string
+		within time.Duration
+		want   float64
+	}{
+		{"current only", ">", 0, 10},
+		{"highest within 2h", ">", 2 * time.Hour, 12},
+		{"lowest within 2h", "<", 2 * time.Hour, 8},
+		{"lowest within 3h", "<", 3 * time.Hour, 3},
+		{"highest within 4h", ">", 4 * time.Hour, 15},
+		{"lowest within 30m", "<", 30 * time.Minute, 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := Rule{Metric: "temp", Op: tc.op, Within: xjson.Duration(tc.within)}
+			if got := r.value(w, "metric", now); got != tc.want {
+				t.Errorf("value() = %g, want %g", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRuleHysteresis(t *testing.T) {
+	for _, tc := range []struct {
+		op         string
+		value      float64
+		hysteresis float64
+		v          float64
+		matches    bool
+		cleared    bool
+	}{
+		{">", 30, 0, 30.5, true, false},
+		{">", 30, 0, 30, false, true},
+		{">", 30, 2, 31, true, false},
+		{">", 30, 2, 29, false, false},
+		{">", 30, 2, 28, false, true},
+		{"<", 0, 0, -1, true, false},
+		{"<", 0, 0, 0, false, true},
+		{"<", 0, 1.5, 1, false, false},
+		{"<", 0, 1.5, 1.5, false, true},
+	} {
+		r := Rule{Op: tc.op, Value: tc.value, Hysteresis: tc.hysteresis}
+		if got := r.matches(tc.v); got != tc.matches {
+			t.Errorf("%s %g matches(%g) = %v, want %v", tc.op, tc.value, tc.v, got, tc.matches)
+		}
+		if got := r.cleared(tc.v); got != tc.cleared {
+			t.Errorf("%s %g ±%g cleared(%g) = %v, want %v", tc.op, tc.value, tc.hysteresis, tc.v, got, tc.cleared)
+		}
+	}
+}
+
+func TestRulesEngine(t *testing.T) {
+	cfg := Config{
+		Units: "metric",
+		Rules: []Rule{{Name: "hot", Metric: "temp", Op: ">", Value: 30, Hysteresis: 2}},
+	}
+	if err := cfg.Rules[0].check(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	cache := newWeatherCache()
+	n := newNotifier(&cfg)
+	e := newRulesEngine(&cfg, cache, n)
+	loc := location{name: "Dublin"}
+	for i, step := range []struct {
+		temp float64
+		// send marks the queued notification as sent, otherwise it is
+		// held back, e.g. by quiet hours.
+		send   bool
+		queued bool
+	}{
+		{temp: 25},
+		{temp: 31, queued: true},
+		// not sent, so it is queued again while the condition holds
+		{temp: 32, queued: true, send: true},
+		{temp: 31},
+		// within the hysteresis
+		{temp: 29},
+		{temp: 31},
+		{temp: 28},
+		{temp: 31, queued: true},
+		// held back, then cleared before it could be sent
+		{temp: 20},
+		{temp: 25},
+	} {
+		cache.SetCurrent(&loc, hourlyTemps(time.Now(), step.temp), nil, nil)
+		e.Evaluate()
+		pending := n.pending
+		n.pending = nil
+		if queued := len(pending) > 0; queued != step.queued {
+			t.Fatalf("step %d, %g°: queued = %v, want %v", i, step.temp, queued, step.queued)
+		}
+		if step.send {
+			for _, p := range pending {
+				p.sent()
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+)
+
+const (
+	// providerUpdatePeriod is how often OpenWeatherMap refreshes its current
+	// weather data. Updates can be aligned to it, see Config.AlignUpdates.
+	providerUpdatePeriod = 10 * time.Minute
+	// providerUpdateDelay is how long to wait after the provider's update
+	// time before fetching, to give the new data time to propagate.
+	providerUpdateDelay = time.Minute
+	// retryInterval is the delay before the first retry after a failed
+	// update. It doubles at each failure, up to the update interval.
+	retryInterval = 30 * time.Second
+	// clockCheckInterval is how often the wall clock is compared against the
+	// monotonic clock to detect a suspend/resume cycle or a clock change.
+	clockCheckInterval = 30 * time.Second
+	// clockJumpThreshold is the minimum difference between the two clocks
+	// that is considered a jump.
+	clockJumpThreshold = time.Minute
+	// jitterFraction is the maximum random delay added to each update, as a
+	// fraction of the configured interval. It stays the same when updates
+	// are slowed down to stay within the API budgets.
+	jitterFraction = 0.1
+)
+
+// scheduler decides when automatic updates happen. An update is due when a
+// value is received on C. After every update, whether automatic or not, Done
+// must be called with its outcome so that the next one can be scheduled.
+type scheduler struct {
+	cfg *Config
+	// interval returns the base interval between updates. If zero, updates
+	// are only done on request.
+	interval func() time.Duration
+	// onSchedule, if not nil, is called every time the next update is
+	// scheduled, with the time it is due at and the base interval. The time
+	// is zero if no update is scheduled.
+	onSchedule func(next time.Time, interval time.Duration)
+
+	C        chan struct{}
+	done     chan error
+	resumed  chan struct{}
+	failures int
+}
+
+func newScheduler(cfg *Config, interval func() time.Duration, onSchedule func(time.Time, time.Duration)) *scheduler {
+	return &scheduler{
+		cfg:        cfg,
+		interval:   interval,
+		onSchedule: onSchedule,
+		C:          make(chan struct{}, 1),
+		done:       make(chan error, 1),
+		resumed:    make(chan struct{}, 1),
+	}
+}
+
+// Done reports the outcome of an update and schedules the next one.
+func (s *scheduler) Done(err error) {
+	// only the latest outcome matters
+	select {
+	case <-s.done:
+	default:
+	}
+	s.done <- err
+}
+
+// Resumed reports that the system has resumed from suspend, and triggers an
+// update unless updates are only done on request.
+func (s *scheduler) Resumed() {
+	select {
+	case s.resumed <- struct{}{}:
+	default:
+	}
+}
+
+func (s *scheduler) fire() {
+	select {
+	case s.C <- struct{}{}:
+	default:
+	}
+}
+
+// delay returns how long to wait before the next update given the base
+// interval, or zero if no update should be scheduled.
+func (s *scheduler) delay(now time.Time, interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	if s.failures > 0 {
+		// back off exponentially, but never wait longer than the interval
+		d := retryInterval << (s.failures - 1)
+		if d <= 0 || d > interval {
+			d = interval
+		}
+		return d
+	}
+	next := now.Add(interval)
+	if s.cfg.AlignUpdates {
+		// move to the first provider update at or after the planned time
+		base := next.Add(-providerUpdateDelay)
+		aligned := base.Truncate(providerUpdatePeriod)
+		if aligned.Before(base) {
+			aligned = aligned.Add(providerUpdatePeriod)
+		}
+		next = aligned.Add(providerUpdateDelay)
+	}
+	jitter := time.Duration(rand.Int63n(int64(float64(s.cfg.Interval)*jitterFraction) + 1))
+	return next.Sub(now) + jitter
+}
+
+// Run schedules updates until ctx is done. Nothing is scheduled until the
+// first call to Done.
+func (s *scheduler) Run(ctx context.Context) {
+	clock := time.NewTicker(clockCheckInterval)
+	defer clock.Stop()
+	lastCheck := time.Now()
+
+	var timer *time.Timer
+	stopTimer := func() {
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+		}
+	}
+	defer stopTimer()
+	for {
+		var timerC <-chan time.Time
+		if timer != nil {
+			timerC = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-s.done:
+			if err != nil {
+				s.failures++
+			} else {
+				s.failures = 0
+			}
+			stopTimer()
+			now := time.Now()
+			interval := s.interval()
+			var next time.Time
+			if d := s.delay(now, interval); d > 0 {
+				timer = time.NewTimer(d)
+				next = now.Add(d)
+				if s.cfg.Debug {
+					log.Printf("Next update in %s (%d consecutive failures)", d.Round(time.Second), s.failures)
+				}
+			}
+			if s.onSchedule != nil {
+				s.onSchedule(next, interval)
+			}
+		case <-timerC:
+			timer = nil
+			s.fire()
+		case <-s.resumed:
+			// restart the clock check, so that the same resume is not
+			// detected twice
+			lastCheck = time.Now()
+			if s.cfg.Interval > 0 {
+				log.Printf("Resumed from suspend, updating now")
+				stopTimer()
+				s.fire()
+			}
+		case now := <-clock.C:
+			// the monotonic clock does not advance while the system is
+			// suspended, but the wall clock does.
+			jump := now.Round(0).Sub(lastCheck.Round(0)) - now.Sub(lastCheck)
+			lastCheck = now
+			if jump < 0 {
+				jump = -jump
+			}
+			if jump >= clockJumpThreshold && s.cfg.Interval > 0 {
+				log.Printf("Wall clock jumped by %s, probably resumed from suspend, updating now", jump.Round(time.Second))
+				stopTimer()
+				s.fire()
+			}
+		}
+	}
+}
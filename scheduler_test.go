@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/xjson"
+)
+
+func TestSchedulerDelay(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 3, 20, 0, time.UTC)
+	for _, tc := range []struct {
+		name     string
+		interval time.Duration
+		// configured is the interval in the configuration, if different
+		// from the one slowed down by the API budgets.
+		configured time.Duration
+		failures   int
+		align      bool
+		// want is the delay before jitter, which adds up to 10% of the
+		// configured interval after a success.
+		want time.Duration
+	}{
+		{name: "on request only", interval: 0, want: 0},
+		{name: "on request only after failure", interval: 0, failures: 3, want: 0},
+		{name: "interval", interval: 15 * time.Minute, want: 15 * time.Minute},
+		{name: "slowed down", interval: 10 * time.Hour, configured: 15 * time.Minute, want: 10 * time.Hour},
+		{name: "first retry", interval: 15 * time.Minute, failures: 1, want: 30 * time.Second},
+		{name: "second retry", interval: 15 * time.Minute, failures: 2, want: time.Minute},
+		{name: "fifth retry", interval: 15 * time.Minute, failures: 5, want: 8 * time.Minute},
+		{name: "retry capped at interval", interval: 15 * time.Minute, failures: 6, want: 15 * time.Minute},
+		{name: "retry overflow capped at interval", interval: 15 * time.Minute, failures: 100, want: 15 * time.Minute},
+		{name: "retry not aligned", interval: 15 * time.Minute, failures: 1, align: true, want: 30 * time.Second},
+		// 12:18:20 moves to the provider update at 12:20, plus a minute
+		{name: "aligned", interval: 15 * time.Minute, align: true, want: 17*time.Minute + 40*time.Second},
+		// 12:04:20 is before 12:11, but after the 12:00 update
+		{name: "aligned short interval", interval: time.Minute, align: true, want: 7*time.Minute + 40*time.Second},
+		// 12:31:00 is exactly a minute after the 12:30 update
+		{name: "aligned exact", interval: 27*time.Minute + 40*time.Second, align: true, want: 27*time.Minute + 40*time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			configured := tc.configured
+			if configured == 0 {
+				configured = tc.interval
+			}
+			s := newScheduler(&Config{Interval: xjson.Duration(configured), AlignUpdates: tc.align}, nil, nil)
+			s.failures = tc.failures
+			maxJitter := time.Duration(0)
+			if tc.failures == 0 {
+				maxJitter = time.Duration(float64(configured) * jitterFraction)
+			}
+			for i := 0; i < 100; i++ {
+				got := s.delay(now, tc.interval)
+				if got < tc.want || got > tc.want+maxJitter {
+					t.Fatalf("delay() = %s, want between %s and %s", got, tc.want, tc.want+maxJitter)
+				}
+			}
+		})
+	}
+}
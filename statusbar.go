@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// status bar formats, for status bars that run a command and read its output
+// instead of showing a tray icon.
+const (
+	// barWaybar prints one JSON object per line, as read by waybar's custom
+	// modules with "return-type": "json".
+	barWaybar = "waybar"
+	// bari3bar speaks the i3bar protocol, as used by i3bar and swaybar.
+	bari3bar = "i3bar"
+	// barPlain prints one line of text per update, for i3blocks, polybar,
+	// tmux and the like.
+	barPlain = "plain"
+)
+
+var barFormats = []string{barWaybar, bari3bar, barPlain}
+
+// statusBarRetry is how long a status bar waits before connecting again to the
+// running instance, after losing it.
+const statusBarRetry = 5 * time.Second
+
+// statusBar prints the weather of the current location to w every time it is
+// updated.
+type statusBar struct {
+	tmpl   *templates
+	format string
+	w      io.Writer
+	enc    *json.Encoder
+	// first is true until the first i3bar status line is printed.
+	first bool
+}
+
+type waybarStatus struct {
+	Text    string `json:"text"`
+	Tooltip string `json:"tooltip"`
+	Class   string `json:"class"`
+}
+
+type i3barBlock struct {
+	Name     string `json:"name"`
+	FullText string `json:"full_text"`
+	Urgent   bool   `json:"urgent,omitempty"`
+}
+
+// checkBarFormat returns an error if format is not a known status bar format.
+func checkBarFormat(format string) error {
+	for _, f := range barFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown status bar format '%s', must be one of %s", format, strings.Join(barFormats, ", "))
+}
+
+func newStatusBar(tmpl *templates, format string, w io.Writer) *statusBar {
+	return &statusBar{tmpl: tmpl, format: format, w: w, enc: json.NewEncoder(w), first: true}
+}
+
+// status returns the text, the tooltip and the class of the current weather.
+// The class is the main weather condition, e.g. "clear" or "rain", or "error"
+// if the latest update failed.
+func (b *statusBar) status(cur *cacheEntry, locs []cacheEntry) (string, string, string) {
+	tmpl := b.tmpl
+	switch {
+	case cur == nil:
+		return "Weather", tmpl.Tooltip(cur, locs), "loading"
+	case cur.Error != "":
+		return "failed to get weather", cur.Error, "error"
+	}
+	class := "unknown"
+	if len(cur.Weather.Current.Weather) > 0 {
+		class = strings.ToLower(cur.Weather.Current.Weather[0].Main)
+	}
+	return tmpl.Title(cur, locs), tmpl.Tooltip(cur, locs), class
+}
+
+// start prints the header of the status bar protocol, if any.
+func (b *statusBar) start() {
+	if b.format == bari3bar {
+		fmt.Fprintln(b.w, `{"version":1}`)
+		fmt.Fprintln(b.w, "[")
+	}
+}
+
+// render prints the status of the given weather.
+func (b *statusBar) render(cur *cacheEntry, locs []cacheEntry) {
+	text, tooltip, class := b.status(cur, locs)
+	var err error
+	switch b.format {
+	case barWaybar:
+		err = b.enc.Encode(waybarStatus{Text: text, Tooltip: tooltip, Class: class})
+	case bari3bar:
+		if !b.first {
+			// status lines after the first one are elements of an
+			// infinite array
+			_, err = fmt.Fprint(b.w, ",")
+		}
+		b.first = false
+		if err == nil {
+			err = b.enc.Encode([]i3barBlock{{Name: progname, FullText: text, Urgent: class == "error"}})
+		}
+	case barPlain:
+		_, err = fmt.Fprintln(b.w, text)
+	}
+	if err != nil {
+		log.Printf("Failed to write status bar output: %v", err)
+	}
+}
+
+// runStatusBar runs the app without a tray, printing the weather to stdout in
+// the given status bar format on every update, until ctx is done.
+func runStatusBar(ctx context.Context, cancel context.CancelFunc, a *app, format string) {
+	b := newStatusBar(a.cfg.templates, format, os.Stdout)
+	b.start()
+	a.quit = cancel
+	render := func() { b.render(a.cache.Current(), a.cache.Locations()) }
+	render()
+	a.cache.OnUpdate(render)
+	a.Start(ctx)
+	<-ctx.Done()
+}
+
+// cacheSnapshot is the content of the cache, as streamed to status bars.
+type cacheSnapshot struct {
+	Current   *cacheEntry  `json:"current"`
+	Locations []cacheEntry `json:"locations"`
+}
+
+// streamCache writes the cache to conn as one JSON object per line, now and
+// after every update, until the client goes away.
+func streamCache(conn net.Conn, cache *weatherCache) {
+	log.Printf("Status bar connected")
+	updated := make(chan struct{}, 1)
+	remove := cache.OnUpdate(func() {
+		// never block updates, a pending notification is enough
+		select {
+		case updated <- struct{}{}:
+		default:
+		}
+	})
+	defer remove()
+	gone := make(chan struct{})
+	go func() {
+		// clients never write after the command, so this returns when
+		// the connection is closed
+		_, _ = io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+	enc := json.NewEncoder(conn)
+	for {
+		if err := conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+			log.Printf("Failed to set deadline: %v", err)
+			return
+		}
+		if err := enc.Encode(cacheSnapshot{Current: cache.Current(), Locations: cache.Locations()}); err != nil {
+			log.Printf("Status bar disconnected: %v", err)
+			return
+		}
+		select {
+		case <-gone:
+			log.Printf("Status bar disconnected")
+			return
+		case <-updated:
+		}
+	}
+}
+
+// watchInstance connects to the running instance and calls fn with its cache,
+// now and after every update, until ctx is done or the connection is lost.
+func watchInstance(ctx context.Context, fn func(*cacheSnapshot)) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", runtimePath(".sock"))
+	if err != nil {
+		return fmt.Errorf("is %s running? %w", progname, err)
+	}
+	defer conn.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	if _, err := fmt.Fprintln(conn, cmdWatch); err != nil {
+		return fmt.Errorf("failed to send command: %w", err)
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var s cacheSnapshot
+		if err := dec.Decode(&s); err != nil {
+			return fmt.Errorf("lost connection: %w", err)
+		}
+		fn(&s)
+	}
+}
+
+// runStatusBarClient prints the weather of the running instance to stdout in
+// the given status bar format on every update, until ctx is done. It keeps
+// trying to connect to the instance while there is none, e.g. during a
+// reload.
+func runStatusBarClient(ctx context.Context, cfg *Config, format string) {
+	b := newStatusBar(cfg.templates, format, os.Stdout)
+	b.start()
+	for {
+		err := watchInstance(ctx, func(s *cacheSnapshot) {
+			b.render(s.Current, s.Locations)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Cannot get the weather from the running instance, retrying in %s: %v", statusBarRetry, err)
+		b.render(&cacheEntry{Error: err.Error()}, nil)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(statusBarRetry):
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Sun altitudes, in degrees, that define sunrise and sunset and the civil and
+// nautical twilights. Sunrise accounts for atmospheric refraction and for the
+// apparent radius of the sun.
+const (
+	sunriseAltitude  = -0.833
+	civilAltitude    = -6
+	nauticalAltitude = -12
+)
+
+// j2000 is the Julian date of 2000-01-01 12:00 UTC.
+const j2000 = 2451545.0
+
+// sunTimes are the times of the sun at a location during one solar day. Times
+// are zero if the sun does not reach the corresponding altitude that day,
+// e.g. there is no sunrise during the polar night.
+type sunTimes struct {
+	NauticalDawn time.Time
+	CivilDawn    time.Time
+	Sunrise      time.Time
+	SolarNoon    time.Time
+	Sunset       time.Time
+	CivilDusk    time.Time
+	NauticalDusk time.Time
+	// DayLength is the time between sunrise and sunset. It is 24 hours
+	// during the polar day and zero during the polar night.
+	DayLength time.Duration
+	// PolarDay is true if the sun does not set, and PolarNight is true if
+	// it does not rise.
+	PolarDay, PolarNight bool
+}
+
+func julianToTime(j float64) time.Time {
+	return time.Unix(int64(math.Round((j-2440587.5)*86400)), 0)
+}
+
+func timeToJulian(t time.Time) float64 {
+	return float64(t.Unix())/86400 + 2440587.5
+}
+
+func sinDeg(deg float64) float64 { return math.Sin(deg * math.Pi / 180) }
+func cosDeg(deg float64) float64 { return math.Cos(deg * math.Pi / 180) }
+
+// sunAnomalyAndLongitude returns the mean anomaly and the ecliptic longitude
+// of the sun, in degrees, d days after J2000.
+func sunAnomalyAndLongitude(d float64) (float64, float64) {
+	m := math.Mod(357.5291+0.98560028*d, 360)
+	// equation of the center
+	c := 1.9148*sinDeg(m) + 0.02*sinDeg(2*m) + 0.0003*sinDeg(3*m)
+	return m, math.Mod(m+c+180+102.9372, 360)
+}
+
+// getSunTimes computes the sun times for the solar day containing t, at the
+// given latitude and longitude, with the sunrise equation. They are accurate
+// to about a minute, which is enough to tell day from night.
+func getSunTimes(t time.Time, lat, lon float64) sunTimes {
+	// mean solar noon closest to t, in days since J2000
+	n := math.Round(timeToJulian(t) - j2000 - 0.0008 + lon/360)
+	meanNoon := n + 0.0008 - lon/360
+	m, l := sunAnomalyAndLongitude(meanNoon)
+	transit := j2000 + meanNoon + 0.0053*sinDeg(m) - 0.0069*sinDeg(2*l)
+	// declination of the sun
+	sinDecl := sinDeg(l) * sinDeg(23.4397)
+	cosDecl := math.Sqrt(1 - sinDecl*sinDecl)
+
+	// hourAngle returns the hour angle in degrees at which the sun reaches
+	// the given altitude, or false if it never does.
+	hourAngle := func(alt float64) (float64, bool) {
+		cosH := (sinDeg(alt) - sinDeg(lat)*sinDecl) / (cosDeg(lat) * cosDecl)
+		if cosH < -1 || cosH > 1 {
+			return 0, false
+		}
+		return math.Acos(cosH) * 180 / math.Pi, true
+	}
+	around := func(alt float64) (time.Time, time.Time) {
+		h, ok := hourAngle(alt)
+		if !ok {
+			return time.Time{}, time.Time{}
+		}
+		return julianToTime(transit - h/360), julianToTime(transit + h/360)
+	}
+
+	s := sunTimes{SolarNoon: julianToTime(transit)}
+	s.NauticalDawn, s.NauticalDusk = around(nauticalAltitude)
+	s.CivilDawn, s.CivilDusk = around(civilAltitude)
+	s.Sunrise, s.Sunset = around(sunriseAltitude)
+	if s.Sunrise.IsZero() {
+		// the sun is either always above or always below the horizon,
+		// depending on its altitude at noon
+		if 90-math.Abs(lat-math.Asin(sinDecl)*180/math.Pi) > sunriseAltitude {
+			s.PolarDay = true
+			s.DayLength = 24 * time.Hour
+		} else {
+			s.PolarNight = true
+		}
+	} else {
+		s.DayLength = s.Sunset.Sub(s.Sunrise)
+	}
+	return s
+}
+
+// isDaytime returns true if the sun is up at the given time and location.
+func isDaytime(t time.Time, lat, lon float64) bool {
+	s := getSunTimes(t, lat, lon)
+	if s.Sunrise.IsZero() {
+		return s.PolarDay
+	}
+	return !t.Before(s.Sunrise) && t.Before(s.Sunset)
+}
+
+// dayNightIcon returns the day or night variant of an OpenWeatherMap icon
+// name, like "01d" or "01n", according to whether the sun is up at the given
+// time and location rather than when the weather was fetched.
+func dayNightIcon(icon string, t time.Time, lat, lon float64) string {
+	if len(icon) != 3 {
+		return icon
+	}
+	if isDaytime(t, lat, lon) {
+		return icon[:2] + "d"
+	}
+	return icon[:2] + "n"
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// sunTolerance is how far the computed sun times can be from the published
+// ones, which are rounded to the minute.
+const sunTolerance = 2 * time.Minute
+
+func TestGetSunTimes(t *testing.T) {
+	utc := func(s string) time.Time {
+		ret, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+	// sunrise, solar noon and sunset as published by timeanddate.com,
+	// converted to UTC
+	for _, tc := range []struct {
+		name                  string
+		t                     time.Time
+		lat, lon              float64
+		sunrise, noon, sunset string
+	}{
+		{
+			name: "london summer solstice", t: utc("2024-06-21 12:00"), lat: 51.5074, lon: -0.1278,
+			sunrise: "2024-06-21 03:43", noon: "2024-06-21 12:02", sunset: "2024-06-21 20:21",
+		},
+		{
+			name: "new york winter solstice", t: utc("2024-12-21 17:00"), lat: 40.7128, lon: -74.0060,
+			sunrise: "2024-12-21 12:16", noon: "2024-12-21 16:54", sunset: "2024-12-21 21:32",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := getSunTimes(tc.t, tc.lat, tc.lon)
+			for _, c := range []struct {
+				name      string
+				got, want time.Time
+			}{
+				{"sunrise", s.Sunrise, utc(tc.sunrise)},
+				{"solar noon", s.SolarNoon, utc(tc.noon)},
+				{"sunset", s.Sunset, utc(tc.sunset)},
+			} {
+				if d := c.got.Sub(c.want); d < -sunTolerance || d > sunTolerance {
+					t.Errorf("%s = %s, want %s", c.name, c.got.UTC().Format("15:04:05"), c.want.Format("15:04"))
+				}
+			}
+			ordered := []time.Time{s.NauticalDawn, s.CivilDawn, s.Sunrise, s.SolarNoon, s.Sunset, s.CivilDusk, s.NauticalDusk}
+			for i := 1; i < len(ordered); i++ {
+				if !ordered[i-1].Before(ordered[i]) {
+					t.Errorf("sun times out of order: %+v", s)
+					break
+				}
+			}
+			if s.PolarDay || s.PolarNight {
+				t.Errorf("PolarDay = %v, PolarNight = %v, want false", s.PolarDay, s.PolarNight)
+			}
+			if want := s.Sunset.Sub(s.Sunrise); s.DayLength != want {
+				t.Errorf("DayLength = %s, want %s", s.DayLength, want)
+			}
+		})
+	}
+}
+
+func TestGetSunTimesPolar(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		t         time.Time
+		lat, lon  float64
+		polarDay  bool
+		civilDawn bool
+		dayLength time.Duration
+		daytime   bool
+	}{
+		{
+			name: "tromsø midnight sun", t: time.Date(2024, 6, 21, 23, 0, 0, 0, time.UTC), lat: 69.6496, lon: 18.9560,
+			polarDay: true, dayLength: 24 * time.Hour, daytime: true,
+		},
+		{
+			// the sun stays below the horizon, but high enough for
+			// civil twilight around noon
+			name: "tromsø polar night", t: time.Date(2024, 12, 21, 11, 0, 0, 0, time.UTC), lat: 69.6496, lon: 18.9560,
+			civilDawn: true,
+		},
+		{
+			name: "mcmurdo midnight sun", t: time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC), lat: -77.8463, lon: 166.6683,
+			polarDay: true, dayLength: 24 * time.Hour, daytime: true,
+		},
+		{
+			name: "mcmurdo polar night", t: time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), lat: -77.8463, lon: 166.6683,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := getSunTimes(tc.t, tc.lat, tc.lon)
+			if !s.Sunrise.IsZero() || !s.Sunset.IsZero() {
+				t.Errorf("sunrise = %s, sunset = %s, want none", s.Sunrise, s.Sunset)
+			}
+			if s.PolarDay != tc.polarDay || s.PolarNight == tc.polarDay {
+				t.Errorf("PolarDay = %v, PolarNight = %v, want PolarDay = %v", s.PolarDay, s.PolarNight, tc.polarDay)
+			}
+			if s.DayLength != tc.dayLength {
+				t.Errorf("DayLength = %s, want %s", s.DayLength, tc.dayLength)
+			}
+			if got := !s.CivilDawn.IsZero(); got != tc.civilDawn {
+				t.Errorf("civil dawn = %s, want one: %v", s.CivilDawn, tc.civilDawn)
+			}
+			if got := isDaytime(tc.t, tc.lat, tc.lon); got != tc.daytime {
+				t.Errorf("isDaytime() = %v, want %v", got, tc.daytime)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/insomniacslk/openweathermap"
+)
+
+// default templates, used when the corresponding option is not set in the
+// config file.
+const (
+	defaultTitleTemplate   = `{{.Name}}: {{temp .Current.Temp}} {{.Description}}`
+	defaultTooltipTemplate = `{{if .Current}}{{.Name}}: {{.Description}}
+Temperature: {{temp .Current.Temp}}, feels like {{temp .Current.FeelsLike}}
+Humidity: {{.Current.Humidity}}%
+Wind: {{speed .Current.WindSpeed}} from {{compass .Current.WindDeg}}
+Pressure: {{.Current.Pressure}} hPa
+Visibility: {{distance .Current.Visibility}}
+UV index: {{round 1 .Current.UVI}}
+Sunrise: {{.Time .Sun.Sunrise}}, sunset: {{.Time .Sun.Sunset}}
+Updated: {{.Updated.Format "15:04"}} ({{age .Updated}}){{if .Error}}
+Last update failed: {{.Error}}{{end}}{{else}}Weather app{{end}}`
+	defaultItemTemplate = `{{.Name}}: {{temp .Current.Temp}} {{.Description}}{{if .AirQuality}} {{aqi .AirQuality.AQI}}{{end}}`
+)
+
+// detailTemplates are the lines of the details submenu of each location.
+var detailTemplates = []string{
+	`Feels like: {{temp .Current.FeelsLike}}`,
+	`Dew point: {{temp .Current.DewPoint}}`,
+	`Heat index: {{temp .Comfort.HeatIndex}}, wind chill: {{temp .Comfort.WindChill}}`,
+	`Humidex: {{round 0 .Comfort.Humidex}}, apparent temperature: {{temp .Comfort.ApparentTemperature}}`,
+	`Humidity: {{.Current.Humidity}}%`,
+	`Pressure: {{.Current.Pressure}} hPa`,
+	`Clouds: {{.Current.Clouds}}%`,
+	`Wind: {{speed .Current.WindSpeed}} from {{compass .Current.WindDeg}}`,
+	`Gusts: {{if .Current.WindGust}}{{speed .Current.WindGust}}{{else}}none{{end}}`,
+	`Visibility: {{distance .Current.Visibility}}`,
+	`UV index: {{round 1 .Current.UVI}}`,
+	`Sunrise: {{.Time .Sun.Sunrise}}, sunset: {{.Time .Sun.Sunset}}`,
+	`Solar noon: {{.Time .Sun.SolarNoon}}, day length: {{duration .Sun.DayLength}}`,
+	`Civil twilight: {{.Time .Sun.CivilDawn}} to {{.Time .Sun.CivilDusk}}`,
+	`Nautical twilight: {{.Time .Sun.NauticalDawn}} to {{.Time .Sun.NauticalDusk}}`,
+	`Moon: {{.Moon.Emoji}} {{.Moon.Name}}, {{percent .Moon.Illumination}} illuminated`,
+	`Moonrise: {{.Time .Moon.Rise}}, moonset: {{.Time .Moon.Set}}`,
+}
+
+// airQualityTemplates are the lines added to the details submenu if
+// air_quality is enabled.
+var airQualityTemplates = []string{
+	`Air quality: {{if .AirQuality}}{{aqi .AirQuality.AQI}}{{else}}unknown{{end}}`,
+	`{{with .AirQuality}}PM2.5 {{round 1 .PM25}}, PM10 {{round 1 .PM10}}, O3 {{round 1 .O3}}, NO2 {{round 1 .NO2}} μg/m³{{else}}Pollutants: unknown{{end}}`,
+}
+
+// templates are the text/template templates used to show the weather in the
+// tray title, in the tooltip and in the location menu items.
+type templates struct {
+	title, tooltip, item *template.Template
+	details              []*template.Template
+	// units are the units of the weather, used to compute the comfort
+	// indices.
+	units string
+}
+
+// templateData is the data the templates are executed with.
+type templateData struct {
+	Name    string
+	Lat     float64
+	Lon     float64
+	Updated time.Time
+	// Error is the error of the latest update, if it failed.
+	Error string
+	// Current, Hourly and Daily are the weather, as returned by
+	// OpenWeatherMap. Current is nil until the first successful update.
+	Current *openweathermap.PointWeatherSummary
+	Hourly  []openweathermap.PointWeatherSummary
+	Daily   []openweathermap.DailyWeatherSummary
+	// AirQuality is the air quality, if air_quality is enabled and it was
+	// fetched successfully.
+	AirQuality *airQuality
+	// Description is the description of the current weather conditions.
+	Description string
+	// Locations are the configured locations. It is only set for the title
+	// and tooltip templates.
+	Locations []templateData
+
+	tz    *time.Location
+	units string
+}
+
+// data returns the data for the templates of a location.
+func (t *templates) data(e *cacheEntry) templateData {
+	d := templateData{
+		Name:       e.Name,
+		Lat:        e.Lat,
+		Lon:        e.Lon,
+		Updated:    e.Updated,
+		Error:      e.Error,
+		AirQuality: e.AirQuality,
+		tz:         time.Local,
+		units:      t.units,
+	}
+	if w := e.Weather; w != nil {
+		d.Current, d.Hourly, d.Daily = w.Current, w.Hourly, w.Daily
+		if w.Current != nil {
+			d.Description = description(w.Current.CommonWeatherSummary)
+		}
+		d.tz = timezone(w)
+	}
+	return d
+}
+
+// Time formats a Unix timestamp, like Current.Sunrise, or a time, like
+// Sun.Sunrise, as a time of the day in the timezone of the location.
+func (d templateData) Time(ts interface{}) (string, error) {
+	if t, ok := ts.(time.Time); ok {
+		if t.IsZero() {
+			return "none", nil
+		}
+		return t.In(d.tz).Format("15:04"), nil
+	}
+	f, err := toFloat(ts)
+	if err != nil {
+		return "", err
+	}
+	return time.Unix(int64(f), 0).In(d.tz).Format("15:04"), nil
+}
+
+// Sun returns the sunrise, sunset and twilight times of today at the location,
+// computed locally.
+func (d templateData) Sun() sunTimes {
+	return getSunTimes(time.Now(), d.Lat, d.Lon)
+}
+
+// Comfort returns the comfort indices of the current weather, or zero values
+// if it is not known yet.
+func (d templateData) Comfort() comfort {
+	if d.Current == nil {
+		return comfort{}
+	}
+	return getComfort(d.Current, d.units)
+}
+
+// Moon returns the phase of the moon, and today's moonrise and moonset at the
+// location, computed locally.
+func (d templateData) Moon() moonInfo {
+	return getMoon(time.Now(), d.tz, d.Lat, d.Lon)
+}
+
+// toFloat converts any number to a float64, so that the template functions
+// accept both the integer and the floating point fields of the weather.
+func toFloat(v interface{}) (float64, error) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return 0, nil
+		}
+		return toFloat(rv.Elem().Interface())
+	}
+	return 0, fmt.Errorf("expected a number, got %T", v)
+}
+
+var compassPoints = []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+
+// compass returns the compass point of a direction in degrees, like WindDeg.
+func compass(v interface{}) (string, error) {
+	deg, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+	i := int(math.Round(math.Mod(deg, 360)/22.5)) % len(compassPoints)
+	if i < 0 {
+		i += len(compassPoints)
+	}
+	return compassPoints[i], nil
+}
+
+// age returns how long ago t was, in minutes.
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	d := time.Since(t).Round(time.Minute)
+	if d < time.Minute {
+		return "just now"
+	}
+	return strings.TrimSuffix(d.String(), "0s") + " ago"
+}
+
+// templateFuncs returns the helper functions available to the templates.
+func templateFuncs(cfg *Config) template.FuncMap {
+	tempUnit := openweathermap.TempUnits[openweathermap.Units(cfg.Units)]
+	speedUnit := openweathermap.SpeedUnits[openweathermap.Units(cfg.Units)]
+	imperial := openweathermap.Units(cfg.Units) == openweathermap.Imperial
+	return template.FuncMap{
+		// round rounds a number to the given number of decimal places.
+		"round": func(places int, v interface{}) (float64, error) {
+			f, err := toFloat(v)
+			if err != nil {
+				return 0, err
+			}
+			p := math.Pow(10, float64(places))
+			return math.Round(f*p) / p, nil
+		},
+		// temp formats a temperature with one decimal place and its unit.
+		"temp": func(v interface{}) (string, error) {
+			f, err := toFloat(v)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%.01f%s", f, tempUnit), nil
+		},
+		// speed formats a wind speed with one decimal place and its unit.
+		"speed": func(v interface{}) (string, error) {
+			f, err := toFloat(v)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%.01f %s", f, speedUnit), nil
+		},
+		// percent formats a probability between 0 and 1, like Pop, as a
+		// percentage.
+		"percent": func(v interface{}) (string, error) {
+			f, err := toFloat(v)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%.0f%%", f*100), nil
+		},
+		// distance formats a distance in meters, like Visibility, in
+		// kilometers or miles.
+		"distance": func(v interface{}) (string, error) {
+			f, err := toFloat(v)
+			if err != nil {
+				return "", err
+			}
+			if imperial {
+				return fmt.Sprintf("%.01f mi", f/1609.344), nil
+			}
+			return fmt.Sprintf("%.01f km", f/1000), nil
+		},
+		// aqi formats an air quality index as its colour-coded level.
+		"aqi":     formatAQI,
+		"compass": compass,
+		"age":     age,
+		// duration formats a duration, like Sun.DayLength, in hours and
+		// minutes.
+		"duration": func(d time.Duration) string {
+			d = d.Round(time.Minute)
+			return fmt.Sprintf("%dh%02dm", d/time.Hour, (d%time.Hour)/time.Minute)
+		},
+	}
+}
+
+// parseTemplates parses the templates in the config file, or the default ones.
+func parseTemplates(cfg *Config) (*templates, error) {
+	funcs := templateFuncs(cfg)
+	parse := func(name, text, def string) (*template.Template, error) {
+		if text == "" {
+			text = def
+		}
+		t, err := template.New(name).Funcs(funcs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		return t, nil
+	}
+	var (
+		t   = templates{units: cfg.Units}
+		err error
+	)
+	if t.title, err = parse("title_template", cfg.TitleTemplate, defaultTitleTemplate); err != nil {
+		return nil, err
+	}
+	if t.tooltip, err = parse("tooltip_template", cfg.TooltipTemplate, defaultTooltipTemplate); err != nil {
+		return nil, err
+	}
+	if t.item, err = parse("item_template", cfg.ItemTemplate, defaultItemTemplate); err != nil {
+		return nil, err
+	}
+	details := detailTemplates
+	if cfg.AirQuality {
+		details = append(details[:len(details):len(details)], airQualityTemplates...)
+	}
+	for _, text := range details {
+		t.details = append(t.details, template.Must(template.New("details").Funcs(funcs).Parse(text)))
+	}
+	return &t, nil
+}
+
+func execTemplate(t *template.Template, data templateData) string {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		log.Printf("Failed to execute %s: %v", t.Name(), err)
+		return "template error"
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// summaryData returns the data for the title and tooltip templates.
+func (t *templates) summaryData(cur *cacheEntry, locs []cacheEntry) templateData {
+	data := templateData{tz: time.Local, units: t.units}
+	if cur != nil {
+		data = t.data(cur)
+	}
+	for i := range locs {
+		data.Locations = append(data.Locations, t.data(&locs[i]))
+	}
+	return data
+}
+
+// Title returns the title for the current location.
+func (t *templates) Title(cur *cacheEntry, locs []cacheEntry) string {
+	return execTemplate(t.title, t.summaryData(cur, locs))
+}
+
+// Tooltip returns the tooltip for the current location and the configured
+// locations.
+func (t *templates) Tooltip(cur *cacheEntry, locs []cacheEntry) string {
+	return execTemplate(t.tooltip, t.summaryData(cur, locs))
+}
+
+// Item returns the menu item for a configured location.
+func (t *templates) Item(e *cacheEntry) string {
+	return execTemplate(t.item, t.data(e))
+}
+
+// Details returns the lines of the details submenu for a configured location.
+func (t *templates) Details(e *cacheEntry) []string {
+	data := t.data(e)
+	lines := make([]string, 0, len(t.details))
+	for _, tmpl := range t.details {
+		lines = append(lines, execTemplate(tmpl, data))
+	}
+	return lines
+}
@@ -0,0 +1,299 @@
+//go:build !headless
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/getlantern/systray"
+	"github.com/insomniacslk/openweathermap/icons"
+)
+
+// runTray runs the app with a tray icon and menu, until the user quits.
+func runTray(ctx context.Context, cancel context.CancelFunc, a *app) {
+	a.quit = systray.Quit
+	systray.Run(
+		func() { onReady(ctx, a) },
+		func() { onExit(cancel) },
+	)
+}
+
+// tray is the menu shown by the tray icon.
+type tray struct {
+	// mu serialises rendering, which happens on updates and at sunrise and
+	// sunset.
+	mu          sync.Mutex
+	a           *app
+	mInterval   *systray.MenuItem
+	mNextUpdate *systray.MenuItem
+	mLastUpdate *systray.MenuItem
+	mUsage      *systray.MenuItem
+	items       []locationMenu
+	// mAccuracy shows the accuracy of past forecasts in its submenu, one
+	// item per location and lead time, if history is enabled.
+	mAccuracy *systray.MenuItem
+	accuracy  []*systray.MenuItem
+}
+
+// locationMenu is the menu item of a configured location, with a submenu
+// showing the details of the current conditions.
+type locationMenu struct {
+	item    *systray.MenuItem
+	details []*systray.MenuItem
+	mOpen   *systray.MenuItem
+}
+
+func addLocationMenu(loc location, tmpl *templates) locationMenu {
+	m := locationMenu{
+		item: systray.AddMenuItem(
+			fmt.Sprintf("%s: not loaded yet", loc.name),
+			fmt.Sprintf("Weather for %s", loc.name),
+		),
+	}
+	for range tmpl.details {
+		d := m.item.AddSubMenuItem("not loaded yet", "")
+		d.Disable()
+		m.details = append(m.details, d)
+	}
+	m.mOpen = m.item.AddSubMenuItem("Open in browser", fmt.Sprintf("Open the forecast for %s in the browser", loc.name))
+	return m
+}
+
+// watch opens the forecast for loc in the browser when requested, until ctx
+// is done.
+func (m locationMenu) watch(ctx context.Context, loc location) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.mOpen.ClickedCh:
+			if err := openBrowser(fmt.Sprintf(forecastURL, loc.lat, loc.lon)); err != nil {
+				log.Printf("Failed to open forecast for '%s': %v", loc.name, err)
+			}
+		}
+	}
+}
+
+// render updates the title, icon and menu from the cache.
+func (t *tray) render() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.renderWeather()
+	t.mLastUpdate.SetTitle(fmt.Sprintf("Last update: %s", time.Now().Format("Mon Jan 2 15:04:05 MST")))
+	t.mUsage.SetTitle(apiUsage.Summary(t.a.cfg))
+}
+
+// weatherIcon returns the icon of the weather of a location, in its day or
+// night variant according to the current position of the sun there.
+func weatherIcon(e *cacheEntry) []byte {
+	return icons.Icons[dayNightIcon(e.Weather.Current.Weather[0].Icon, time.Now(), e.Lat, e.Lon)]
+}
+
+// renderWeather updates the title, icon, tooltip and location items from the
+// cache. The icons of stale entries are updated too.
+func (t *tray) renderWeather() {
+	cfg := t.a.cfg
+	cur, locs := t.a.cache.Current(), t.a.cache.Locations()
+	if cur != nil {
+		if cur.Error != "" {
+			systray.SetTitle("failed to get weather")
+		} else {
+			systray.SetTitle(cfg.templates.Title(cur, locs))
+		}
+		var icon []byte
+		if t.a.graph != nil {
+			var err error
+			if icon, err = t.a.graph.ToIcon(); err != nil {
+				log.Printf("Failed to convert to icon, skipping: %v", err)
+			}
+		} else if cur.Weather != nil {
+			icon = weatherIcon(cur)
+		}
+		if icon != nil && cfg.MoonOverlay && !isDaytime(time.Now(), cur.Lat, cur.Lon) {
+			phase, _ := moonPhase(time.Now())
+			withMoon, err := moonOverlay(icon, phase)
+			if err != nil {
+				log.Printf("Failed to draw the moon on the icon, skipping: %v", err)
+			} else {
+				icon = withMoon
+			}
+		}
+		if icon != nil {
+			systray.SetIcon(icon)
+		}
+	}
+	systray.SetTooltip(cfg.templates.Tooltip(cur, locs))
+	for i, e := range locs {
+		m := t.items[i]
+		if e.Weather != nil {
+			m.item.SetIcon(weatherIcon(&e))
+		}
+		if e.Error != "" || e.Weather == nil {
+			m.item.SetTitle("failed to update")
+			continue
+		}
+		m.item.SetTitle(cfg.templates.Item(&e))
+		for j, line := range cfg.templates.Details(&e) {
+			m.details[j].SetTitle(line)
+		}
+	}
+}
+
+// watchDaylight renders the weather again when the sun rises or sets at any of
+// the locations, so that icons switch between their day and night variants
+// even if no update happens, until ctx is done. In between, it renders the
+// tooltip every minute, to keep the age of the data in it current.
+func (t *tray) watchDaylight(ctx context.Context) {
+	daylight := func() []bool {
+		var ret []bool
+		if cur := t.a.cache.Current(); cur != nil {
+			ret = append(ret, isDaytime(time.Now(), cur.Lat, cur.Lon))
+		}
+		for _, e := range t.a.cache.Locations() {
+			ret = append(ret, isDaytime(time.Now(), e.Lat, e.Lon))
+		}
+		return ret
+	}
+	prev := daylight()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cur := daylight()
+			t.mu.Lock()
+			if !reflect.DeepEqual(cur, prev) {
+				t.renderWeather()
+			} else {
+				systray.SetTooltip(t.a.cfg.templates.Tooltip(t.a.cache.Current(), t.a.cache.Locations()))
+			}
+			t.mu.Unlock()
+			prev = cur
+		}
+	}
+}
+
+// renderAccuracy shows the accuracy of past forecasts.
+func (t *tray) renderAccuracy(stats []accuracyStats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(stats) == 0 {
+		t.mAccuracy.SetTitle("Forecast accuracy: not enough history yet")
+	} else {
+		t.mAccuracy.SetTitle("Forecast accuracy")
+	}
+	for i, s := range stats {
+		if i == len(t.accuracy) {
+			item := t.mAccuracy.AddSubMenuItem("", "Mean absolute error of the temperature, and share of hours the forecast was right about precipitation")
+			item.Disable()
+			t.accuracy = append(t.accuracy, item)
+		}
+		t.accuracy[i].SetTitle(s.summary(t.a.cfg.Units))
+		t.accuracy[i].Show()
+	}
+	for _, item := range t.accuracy[len(stats):] {
+		item.Hide()
+	}
+}
+
+// onSchedule shows the interval and the time of the next update.
+func (t *tray) onSchedule(next time.Time, interval time.Duration) {
+	cfg := t.a.cfg
+	if cfg.Interval != 0 {
+		if interval != time.Duration(cfg.Interval) {
+			t.mInterval.SetTitle(fmt.Sprintf("Weather will update every %s (slowed down by API budget)", interval.Round(time.Second)))
+		} else {
+			t.mInterval.SetTitle(fmt.Sprintf("Weather will update every %s", cfg.Interval))
+		}
+	}
+	if next.IsZero() {
+		t.mNextUpdate.SetTitle("Next update: not scheduled")
+	} else {
+		t.mNextUpdate.SetTitle(fmt.Sprintf("Next update: %s", next.Format("15:04:05")))
+	}
+}
+
+func onReady(ctx context.Context, a *app) {
+	cfg := a.cfg
+	if a.graph != nil {
+		icon, err := a.graph.ToIcon()
+		if err != nil {
+			log.Fatalf("Failed to convert to icon: %v", err)
+		}
+		systray.SetIcon(icon)
+	}
+
+	// use the weather icon if the user is not requesting the temperature graph
+	if !cfg.ShowGraph {
+		systray.SetIcon(icons.Icon01d)
+	}
+	systray.SetTitle("Weather")
+	systray.SetTooltip("Weather app")
+
+	t := tray{a: a}
+	mUpdate := systray.AddMenuItem("Update weather now", "Force an update of the weather information for all the locations")
+	if cfg.Interval == 0 {
+		t.mInterval = systray.AddMenuItem("Weather will not update automatically", "No interval is defined in the config file, or zero is set")
+	} else {
+		t.mInterval = systray.AddMenuItem(fmt.Sprintf("Weather will update every %s", cfg.Interval), "The weather information will automatically update at the configured interval")
+	}
+	t.mNextUpdate = systray.AddMenuItem("Next update: not scheduled", "Show when the next automatic update will happen")
+	t.mNextUpdate.Disable()
+	t.mLastUpdate = systray.AddMenuItem("Last updated: never", "Show the last time weather was updated")
+	t.mUsage = systray.AddMenuItem(apiUsage.Summary(cfg), "Show the API calls made today, and the remaining daily budget")
+	t.mLastUpdate.Disable()
+	t.mInterval.Disable()
+	t.mUsage.Disable()
+	if cfg.History {
+		t.mAccuracy = systray.AddMenuItem("Forecast accuracy: not enough history yet", "Show how accurate past forecasts were, per location and lead time")
+	}
+	mEdit := systray.AddMenuItem("Edit config", "Open configuration file for editing")
+	systray.AddSeparator()
+
+	// Sets the icon of a menu item. Only available on Mac and Windows.
+
+	for _, loc := range a.locs {
+		m := addLocationMenu(loc, cfg.templates)
+		t.items = append(t.items, m)
+		go m.watch(ctx, loc)
+	}
+	systray.AddSeparator()
+	mQuit := systray.AddMenuItem("Quit", "Terminate the app")
+	mQuit.SetIcon(Icon)
+
+	a.onSchedule = t.onSchedule
+	a.cache.OnUpdate(t.render)
+	a.Start(ctx)
+	go t.watchDaylight(ctx)
+	if cfg.History {
+		go watchAccuracy(ctx, t.renderAccuracy)
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-mQuit.ClickedCh:
+				systray.Quit()
+			case <-mEdit.ClickedCh:
+				if err := editConfigFile(cfg, a.configFile); err != nil {
+					log.Printf("Failed to edit config file: %v", err)
+				}
+			case <-mUpdate.ClickedCh:
+				a.Refresh()
+			}
+		}
+	}()
+}
+
+func onExit(cancel context.CancelFunc) {
+	// cancel any outstanding requests
+	cancel()
+}
@@ -0,0 +1,15 @@
+//go:build headless
+
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// runTray is not available when building with the headless tag, which drops
+// the dependency on the system tray libraries, so it always runs headless.
+func runTray(ctx context.Context, cancel context.CancelFunc, a *app) {
+	log.Printf("Built without tray support, running headless")
+	runHeadless(ctx, cancel, a)
+}
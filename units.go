@@ -0,0 +1,35 @@
+package main
+
+import "github.com/insomniacslk/openweathermap"
+
+// toCelsius converts a temperature in the given units to degrees Celsius.
+func toCelsius(t float64, units string) float64 {
+	switch openweathermap.Units(units) {
+	case openweathermap.Standard:
+		return t - 273.15
+	case openweathermap.Imperial:
+		return (t - 32) * 5 / 9
+	default:
+		return t
+	}
+}
+
+// toMetersPerSecond converts a speed in the given units to meters per second.
+func toMetersPerSecond(v float64, units string) float64 {
+	if openweathermap.Units(units) == openweathermap.Imperial {
+		return v * 0.44704
+	}
+	return v
+}
+
+// fromCelsius converts a temperature in degrees Celsius to the given units.
+func fromCelsius(t float64, units string) float64 {
+	switch openweathermap.Units(units) {
+	case openweathermap.Standard:
+		return t + 273.15
+	case openweathermap.Imperial:
+		return t*9/5 + 32
+	default:
+		return t
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// updater runs weather updates one at a time. Starting a new update cancels
+// the one in flight, if any, and waits for it to return, so that a newer
+// update always supersedes an older one.
+type updater struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Run cancels the running update, if any, and starts fn in a new goroutine
+// with a context derived from ctx.
+func (u *updater) Run(ctx context.Context, fn func(ctx context.Context)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stop()
+	uctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	u.cancel, u.done = cancel, done
+	go func() {
+		defer close(done)
+		defer cancel()
+		fn(uctx)
+	}()
+}
+
+// Stop cancels the running update, if any, and waits for it to return.
+func (u *updater) Stop() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stop()
+}
+
+func (u *updater) stop() {
+	if u.cancel == nil {
+		return
+	}
+	u.cancel()
+	<-u.done
+	u.cancel, u.done = nil, nil
+}